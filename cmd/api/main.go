@@ -1,33 +1,162 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"skyphin-api/internal/config"
 	"skyphin-api/internal/controllers"
 	"skyphin-api/internal/middleware"
-	"skyphin-api/internal/models"
+	"skyphin-api/internal/migrations"
 	"skyphin-api/internal/repositories"
 	"skyphin-api/internal/services"
 	"skyphin-api/pkg/database"
+	"skyphin-api/pkg/mailer"
+	"skyphin-api/pkg/password"
+	"skyphin-api/pkg/ratelimit"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gorm.io/gorm"
 )
 
+const (
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+type appRepositories struct {
+	user     *repositories.UserRepository
+	auth     *repositories.AuthRepository
+	mfa      *repositories.MFARepository
+	webAuthn *repositories.WebAuthnRepository
+	outbox   *repositories.OutboxRepository
+	rbac     *repositories.RBACRepository
+	org      *repositories.OrganizationRepository
+	lockout  *repositories.LockoutRepository
+	audit    *repositories.AuditRepository
+	session  *repositories.SessionRepository
+	apiKey   *repositories.APIKeyRepository
+	oauth    *repositories.OAuthRepository
+	identity *repositories.IdentityRepository
+	tx       *repositories.Transactor
+}
+
+type appServices struct {
+	user       *services.UserService
+	auth       *services.AuthService
+	mfa        *services.MFAService
+	webAuthn   *services.WebAuthnService
+	outbox     *services.OutboxDispatcher
+	janitor    *services.TokenJanitor
+	rbac       *services.RBACService
+	org        *services.OrganizationService
+	lockout    *services.LockoutService
+	audit      *services.AuditService
+	apiKey     *services.APIKeyService
+	oauth      *services.OAuthService
+	oidc       *services.OIDCService
+	federation *services.FederationService
+	magicLink  *services.MagicLinkService
+}
+
+type appControllers struct {
+	user       *controllers.UserController
+	auth       *controllers.AuthController
+	mfa        *controllers.MFAController
+	webAuthn   *controllers.WebAuthnController
+	outbox     *controllers.OutboxController
+	rbac       *controllers.RBACController
+	org        *controllers.OrganizationController
+	lockout    *controllers.LockoutController
+	audit      *controllers.AuditController
+	session    *controllers.SessionController
+	apiKey     *controllers.APIKeyController
+	oauth      *controllers.OAuthController
+	oidc       *controllers.OIDCController
+	federation *controllers.FederationController
+	magicLink  *controllers.MagicLinkController
+}
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	cfg := loadConfig()
 	db := connectDatabase(cfg)
-	migrateDatabase(db)
+	hasher := initializeTokenHasher(cfg)
+	migrateDatabase(db, hasher, cfg)
+
+	// Workers get their own context so that they keep running while the
+	// HTTP server drains, and stop only after it has.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
 
-	userRepo, authRepo := initializeRepositories(db)
-	userService, authService := initializeServices(userRepo, authRepo, cfg)
-	userController, authController := initializeControllers(userService, authService)
-	authMiddleware := middleware.NewAuthMiddleware(authService, cfg)
+	keyManager := initializeKeyManager(cfg)
+	runWorker(workerCtx, &workers, keyManager.Run)
 
-	router := setupRouter(userController, authController, authMiddleware)
+	repos := initializeRepositories(db, hasher)
+	svcs := initializeServices(repos, keyManager, cfg)
+	runWorker(workerCtx, &workers, svcs.outbox.Run)
+	runWorker(workerCtx, &workers, svcs.janitor.Run)
 
-	startServer(router, cfg)
+	ctrls := initializeControllers(svcs)
+	authMiddleware := middleware.NewAuthMiddleware(svcs.auth, svcs.apiKey, cfg)
+	rateLimiter := initializeRateLimiter(cfg)
+
+	router := setupRouter(ctrls, authMiddleware, rateLimiter, cfg)
+
+	servers := []*http.Server{newServer(router, cfg.Server.Address, cfg)}
+	if cfg.Server.MetricsAddress != "" {
+		// Metrics get their own address so they are not exposed next to the API.
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		servers = append(servers, newServer(mux, cfg.Server.MetricsAddress, cfg))
+	}
+
+	serverErrs := make(chan error, len(servers))
+	go func() { serverErrs <- serve(servers[0], cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile) }()
+	for _, server := range servers[1:] {
+		go func() { serverErrs <- serve(server, "", "") }()
+	}
+
+	var serverErr error
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down")
+	case serverErr = <-serverErrs:
+		log.Printf("Failed to start server: %v", serverErr)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.SecondsOr(cfg.Server.ShutdownTimeoutSeconds, defaultShutdownTimeout))
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to drain server on %s: %v", server.Addr, err)
+		}
+	}
+
+	stopWorkers()
+	if err := waitFor(shutdownCtx, &workers); err != nil {
+		log.Printf("Failed to stop background workers: %v", err)
+	}
+
+	closeDatabase(db)
+	if serverErr != nil {
+		os.Exit(1)
+	}
 }
 
 func loadConfig() config.Config {
@@ -35,6 +164,9 @@ func loadConfig() config.Config {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if (cfg.Server.TLSCertFile == "") != (cfg.Server.TLSKeyFile == "") {
+		log.Fatalf("Failed to load config: TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
 	return cfg
 }
 
@@ -46,50 +178,339 @@ func connectDatabase(cfg config.Config) *gorm.DB {
 	return db
 }
 
-func migrateDatabase(db *gorm.DB) {
-	if err := db.AutoMigrate(&models.User{}, &models.AccessToken{}, &models.RefreshToken{}, &models.VerificationToken{}, &models.ResetToken{}); err != nil {
+func initializeTokenHasher(cfg config.Config) *repositories.TokenHasher {
+	hasher, err := repositories.NewTokenHasher(cfg.Auth.TokenHashKey)
+	if err != nil {
+		log.Fatalf("Failed to configure token hashing: %v", err)
+	}
+	return hasher
+}
+
+func migrateDatabase(db *gorm.DB, hasher *repositories.TokenHasher, cfg config.Config) {
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	if cfg.DB.SkipMigrations {
+		pending, err := migrator.Pending()
+		if err != nil {
+			log.Fatalf("Failed to check migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("Database has %d pending migrations, run skyphinctl migrate up first", len(pending))
+		}
+	} else if err := migrator.Up(); err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+
+	if err := repositories.HashStoredTokens(db, hasher); err != nil {
+		log.Fatalf("Failed to hash stored tokens: %v", err)
+	}
+
+	drift, err := migrator.Drift()
+	if err != nil {
+		log.Fatalf("Failed to compare schema with models: %v", err)
+	}
+	for _, difference := range drift {
+		log.Printf("Schema drift: %s", difference)
+	}
 }
 
-func initializeRepositories(db *gorm.DB) (*repositories.UserRepository, *repositories.AuthRepository) {
-	userRepo := repositories.NewUserRepository(db)
-	authRepo := repositories.NewAuthRepository(db)
-	return userRepo, authRepo
+func initializeKeyManager(cfg config.Config) *services.KeyManager {
+	keyManager, err := services.NewKeyManager(cfg.Auth)
+	if err != nil {
+		log.Fatalf("Failed to load signing keys: %v", err)
+	}
+	if !keyManager.HasAsymmetricKey() {
+		log.Printf("No SIGNING_KEY_FILES configured: tokens are signed with HS256 and the openid scope is refused")
+	}
+	return keyManager
 }
 
-func initializeServices(userRepo *repositories.UserRepository, authRepo *repositories.AuthRepository, cfg config.Config) (*services.UserService, *services.AuthService) {
-	userService := services.NewUserService(userRepo)
-	authService := services.NewAuthService(userRepo, authRepo, cfg)
-	return userService, authService
+func initializeRateLimiter(cfg config.Config) *middleware.RateLimitMiddleware {
+	store, err := ratelimit.New(cfg.RateLimit)
+	if err != nil {
+		log.Fatalf("Failed to configure rate limiting: %v", err)
+	}
+
+	rateLimiter, err := middleware.NewRateLimitMiddleware(store, cfg.RateLimit)
+	if err != nil {
+		log.Fatalf("Failed to configure rate limiting: %v", err)
+	}
+	return rateLimiter
 }
 
-func initializeControllers(userService *services.UserService, authService *services.AuthService) (*controllers.UserController, *controllers.AuthController) {
-	userController := controllers.NewUserController(userService)
-	authController := controllers.NewAuthController(authService, userService)
-	return userController, authController
+func initializeRepositories(db *gorm.DB, hasher *repositories.TokenHasher) appRepositories {
+	return appRepositories{
+		user:     repositories.NewUserRepository(db),
+		auth:     repositories.NewAuthRepository(db, hasher),
+		mfa:      repositories.NewMFARepository(db, hasher),
+		webAuthn: repositories.NewWebAuthnRepository(db, hasher),
+		outbox:   repositories.NewOutboxRepository(db),
+		rbac:     repositories.NewRBACRepository(db),
+		org:      repositories.NewOrganizationRepository(db, hasher),
+		lockout:  repositories.NewLockoutRepository(db, hasher),
+		audit:    repositories.NewAuditRepository(db),
+		session:  repositories.NewSessionRepository(db),
+		apiKey:   repositories.NewAPIKeyRepository(db, hasher),
+		oauth:    repositories.NewOAuthRepository(db, hasher),
+		identity: repositories.NewIdentityRepository(db, hasher),
+		tx:       repositories.NewTransactor(db),
+	}
 }
 
-func setupRouter(userController *controllers.UserController, authController *controllers.AuthController, authMiddleware *middleware.AuthMiddleware) *gin.Engine {
+func initializeServices(repos appRepositories, keyManager *services.KeyManager, cfg config.Config) appServices {
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
+
+	emailService := services.NewEmailService(mail, cfg)
+
+	auditService := services.NewAuditService(repos.audit)
+
+	rbacService := services.NewRBACService(repos.user, repos.rbac, auditService, cfg)
+	if err := rbacService.Seed(); err != nil {
+		log.Fatalf("Failed to seed roles: %v", err)
+	}
+
+	orgService := services.NewOrganizationService(repos.user, repos.org, repos.outbox, repos.tx)
+	hasher, err := password.New(cfg.Password)
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	policy, err := password.NewPolicy(cfg.Password)
+	if err != nil {
+		log.Fatalf("Failed to configure password policy: %v", err)
+	}
+
+	passwordService := services.NewPasswordService(repos.user, hasher, policy, cfg)
+
+	lockoutService := services.NewLockoutService(repos.user, repos.lockout, repos.outbox, repos.tx, auditService, cfg)
+
+	webAuthnService, err := services.NewWebAuthnService(repos.user, repos.webAuthn, lockoutService, auditService, cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure WebAuthn: %v", err)
+	}
+
+	authService := services.NewAuthService(repos.user, repos.auth, repos.session, repos.outbox, repos.tx, rbacService, orgService, lockoutService, passwordService, auditService, keyManager, cfg)
+
+	federationService, err := services.NewFederationService(repos.user, repos.identity, repos.tx, lockoutService, auditService, cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure federated login: %v", err)
+	}
+
+	return appServices{
+		user:       services.NewUserService(repos.user, passwordService),
+		auth:       authService,
+		mfa:        services.NewMFAService(repos.user, repos.mfa, passwordService, auditService, cfg),
+		webAuthn:   webAuthnService,
+		outbox:     services.NewOutboxDispatcher(repos.outbox, emailService, cfg),
+		janitor:    services.NewTokenJanitor(repos.auth, repos.tx, cfg),
+		rbac:       rbacService,
+		org:        orgService,
+		lockout:    lockoutService,
+		audit:      auditService,
+		apiKey:     services.NewAPIKeyService(repos.apiKey, rbacService, orgService, auditService),
+		oauth:      services.NewOAuthService(repos.oauth, authService, repos.tx, auditService, cfg),
+		oidc:       services.NewOIDCService(repos.user, keyManager, cfg),
+		federation: federationService,
+		magicLink:  services.NewMagicLinkService(repos.user, repos.auth, repos.outbox, repos.tx, lockoutService, auditService, keyManager, cfg),
+	}
+}
+
+func initializeControllers(svcs appServices) appControllers {
+	return appControllers{
+		user:       controllers.NewUserController(svcs.user),
+		auth:       controllers.NewAuthController(svcs.auth, svcs.user, svcs.mfa),
+		mfa:        controllers.NewMFAController(svcs.mfa),
+		webAuthn:   controllers.NewWebAuthnController(svcs.webAuthn, svcs.auth),
+		outbox:     controllers.NewOutboxController(svcs.outbox),
+		rbac:       controllers.NewRBACController(svcs.rbac),
+		org:        controllers.NewOrganizationController(svcs.org, svcs.auth),
+		lockout:    controllers.NewLockoutController(svcs.lockout),
+		audit:      controllers.NewAuditController(svcs.audit),
+		session:    controllers.NewSessionController(svcs.auth),
+		apiKey:     controllers.NewAPIKeyController(svcs.apiKey),
+		oauth:      controllers.NewOAuthController(svcs.oauth),
+		oidc:       controllers.NewOIDCController(svcs.oidc),
+		federation: controllers.NewFederationController(svcs.federation, svcs.auth, svcs.mfa),
+		magicLink:  controllers.NewMagicLinkController(svcs.magicLink, svcs.auth, svcs.mfa),
+	}
+}
+
+func setupRouter(ctrls appControllers, authMiddleware *middleware.AuthMiddleware, rateLimiter *middleware.RateLimitMiddleware, cfg config.Config) *gin.Engine {
 	router := gin.Default()
+	// ClientIP keys rate limits and lockouts, so forwarding headers are only
+	// believed from configured proxies.
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(middleware.RequestID())
+
+	limit := func(policy string) gin.HandlerFunc {
+		handler, err := rateLimiter.Limit(policy)
+		if err != nil {
+			log.Fatalf("Failed to configure rate limiting: %v", err)
+		}
+		return handler
+	}
 
-	router.POST("/users", userController.CreateUser)
-	router.POST("/login", authController.Login)
-	router.POST("/refresh", authController.Refresh)
-	router.POST("/verify", authController.Verify)
-	router.POST("/reset-password-request", authController.ResetPasswordRequest)
-	router.POST("/reset-password", authController.ResetPassword)
+	public := router.Group("/")
+	public.Use(limit("auth_ip"))
+	{
+		public.POST("/users", limit("signup_ip"), ctrls.user.CreateUser)
+		public.POST("/register", limit("signup_ip"), ctrls.auth.Register)
+		public.POST("/login", limit("login_email"), ctrls.auth.Login)
+		public.POST("/login/mfa", limit("mfa_token"), ctrls.auth.LoginMFA)
+		public.POST("/login/magic-link", limit("magic_link_email"), ctrls.magicLink.Request)
+		public.POST("/login/magic-link/verify", limit("magic_link_binding"), ctrls.magicLink.Verify)
+		public.POST("/refresh", limit("refresh_token"), ctrls.auth.Refresh)
+		public.POST("/verify", limit("verify_token"), ctrls.auth.Verify)
+		public.POST("/reset-password-request", limit("reset_email"), ctrls.auth.ResetPasswordRequest)
+		public.POST("/reset-password", limit("reset_token"), ctrls.auth.ResetPassword)
+		public.POST("/unlock", limit("unlock_token"), ctrls.lockout.Unlock)
+		public.POST("/webauthn/login/begin", ctrls.webAuthn.BeginLogin)
+		public.POST("/webauthn/login/finish", ctrls.webAuthn.FinishLogin)
+		public.GET("/login/federated", ctrls.federation.ListProviders)
+		public.GET("/login/federated/:provider", ctrls.federation.Begin)
+		public.GET("/login/federated/:provider/callback", ctrls.federation.Callback)
+		public.POST("/login/federated/exchange", ctrls.federation.Exchange)
+		public.GET("/oauth/authorize", ctrls.oauth.Authorize)
+		public.POST("/oauth/token", ctrls.oauth.Token)
+	}
+	router.GET("/.well-known/jwks.json", ctrls.auth.JWKS)
+	router.GET("/.well-known/openid-configuration", ctrls.oidc.Discovery)
+	router.GET("/userinfo", authMiddleware.AuthenticateClientToken(services.ScopeOpenID), ctrls.oidc.UserInfo)
+	router.POST("/userinfo", authMiddleware.AuthenticateClientToken(services.ScopeOpenID), ctrls.oidc.UserInfo)
+	router.POST("/oauth/introspect", authMiddleware.AuthenticateClient(services.ScopeIntrospect), ctrls.oauth.Introspect)
 
+	// Interactive users only. Credentials, MFA, linked identities, sessions
+	// and organizations must not be manageable with an API key.
 	protected := router.Group("/v1")
-	protected.Use(authMiddleware.Authenticate())
+	protected.Use(authMiddleware.Authenticate(), limit("api_user"))
+	{
+		protected.POST("/logout", ctrls.auth.Logout)
+		protected.POST("/logout-all", ctrls.auth.LogoutAll)
+		protected.GET("/sessions", ctrls.session.ListSessions)
+		protected.DELETE("/sessions/:id", ctrls.session.RevokeSession)
+		protected.GET("/identities", ctrls.federation.ListIdentities)
+		protected.DELETE("/identities/:id", ctrls.federation.Unlink)
+		protected.POST("/api-keys", ctrls.apiKey.CreateAPIKey)
+		protected.GET("/api-keys", ctrls.apiKey.ListAPIKeys)
+		protected.DELETE("/api-keys/:id", ctrls.apiKey.RevokeAPIKey)
+		protected.GET("/oauth/authorize", ctrls.oauth.ConsentDetails)
+		protected.POST("/oauth/authorize", ctrls.oauth.Decide)
+		protected.POST("/mfa/totp", ctrls.mfa.EnrollTOTP)
+		protected.POST("/mfa/totp/confirm", ctrls.mfa.ConfirmTOTP)
+		protected.DELETE("/mfa/totp", ctrls.mfa.DisableTOTP)
+		protected.POST("/webauthn/register/begin", ctrls.webAuthn.BeginRegistration)
+		protected.POST("/webauthn/register/finish", ctrls.webAuthn.FinishRegistration)
+		protected.GET("/webauthn/credentials", ctrls.webAuthn.ListCredentials)
+		protected.DELETE("/webauthn/credentials/:id", ctrls.webAuthn.DeleteCredential)
+		protected.POST("/orgs", ctrls.org.CreateOrganization)
+		protected.GET("/orgs", ctrls.org.ListOrganizations)
+		protected.POST("/orgs/:org_id/activate", ctrls.org.ActivateOrganization)
+		protected.GET("/orgs/:org_id/members", ctrls.org.ListMembers)
+		protected.PUT("/orgs/:org_id/members/:user_id", ctrls.org.UpdateMember)
+		protected.DELETE("/orgs/:org_id/members/:user_id", ctrls.org.RemoveMember)
+		protected.POST("/orgs/:org_id/invitations", ctrls.org.InviteMember)
+		protected.GET("/orgs/:org_id/invitations", ctrls.org.ListInvitations)
+		protected.DELETE("/orgs/:org_id/invitations/:id", ctrls.org.RevokeInvitation)
+		protected.GET("/invitations", ctrls.org.ListMyInvitations)
+		protected.POST("/invitations/accept", ctrls.org.AcceptInvitation)
+		protected.POST("/invitations/decline", ctrls.org.DeclineInvitation)
+	}
+
+	// The only routes API keys can call. Each must require a permission.
+	admin := router.Group("/v1/admin")
+	admin.Use(authMiddleware.AuthenticateScoped(), limit("api_user"))
 	{
-		// TODO
+		admin.GET("/users/:id", authMiddleware.RequirePermission(services.PermissionUsersRead), ctrls.user.GetUserById)
+		admin.POST("/users/:id/unlock", authMiddleware.RequirePermission(services.PermissionUsersWrite), ctrls.lockout.UnlockUser)
+		admin.GET("/users/:id/roles", authMiddleware.RequirePermission(services.PermissionRolesRead), ctrls.rbac.ListUserRoles)
+		admin.POST("/users/:id/roles", authMiddleware.RequirePermission(services.PermissionRolesWrite), ctrls.rbac.AssignRole)
+		admin.DELETE("/users/:id/roles/:role_id", authMiddleware.RequirePermission(services.PermissionRolesWrite), ctrls.rbac.RemoveRole)
+		admin.GET("/roles", authMiddleware.RequirePermission(services.PermissionRolesRead), ctrls.rbac.ListRoles)
+		admin.POST("/roles", authMiddleware.RequirePermission(services.PermissionRolesWrite), ctrls.rbac.CreateRole)
+		admin.PUT("/roles/:id", authMiddleware.RequirePermission(services.PermissionRolesWrite), ctrls.rbac.UpdateRole)
+		admin.DELETE("/roles/:id", authMiddleware.RequirePermission(services.PermissionRolesWrite), ctrls.rbac.DeleteRole)
+		admin.GET("/permissions", authMiddleware.RequirePermission(services.PermissionRolesRead), ctrls.rbac.ListPermissions)
+		admin.GET("/audit-events", authMiddleware.RequirePermission(services.PermissionAuditRead), ctrls.audit.ListEvents)
+		admin.GET("/audit-events/export", authMiddleware.RequirePermission(services.PermissionAuditRead), ctrls.audit.ExportEvents)
+		admin.GET("/oauth/clients", authMiddleware.RequirePermission(services.PermissionOAuthClientsRead), ctrls.oauth.ListClients)
+		admin.POST("/oauth/clients", authMiddleware.RequirePermission(services.PermissionOAuthClientsWrite), ctrls.oauth.CreateClient)
+		admin.DELETE("/oauth/clients/:id", authMiddleware.RequirePermission(services.PermissionOAuthClientsWrite), ctrls.oauth.DeleteClient)
+		admin.GET("/outbox", authMiddleware.RequirePermission(services.PermissionOutboxRead), ctrls.outbox.ListMessages)
+		admin.POST("/outbox/:id/retry", authMiddleware.RequirePermission(services.PermissionOutboxWrite), ctrls.outbox.Retry)
 	}
 	return router
 }
 
-func startServer(router *gin.Engine, cfg config.Config) {
-	if err := router.Run(cfg.Server.Address); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+// newServer returns a server for handler with the configured timeouts and
+// header limit.
+func newServer(handler http.Handler, address string, cfg config.Config) *http.Server {
+	return &http.Server{
+		Addr:           address,
+		Handler:        handler,
+		ReadTimeout:    config.SecondsOr(cfg.Server.ReadTimeoutSeconds, defaultReadTimeout),
+		WriteTimeout:   config.SecondsOr(cfg.Server.WriteTimeoutSeconds, defaultWriteTimeout),
+		IdleTimeout:    config.SecondsOr(cfg.Server.IdleTimeoutSeconds, defaultIdleTimeout),
+		MaxHeaderBytes: cfg.Server.MaxHeaderBytes,
+		TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+}
+
+// serve blocks until the server is shut down. It serves HTTPS when given a
+// certificate.
+func serve(server *http.Server, certFile string, keyFile string) error {
+	var err error
+	if certFile != "" {
+		log.Printf("Serving HTTPS on %s", server.Addr)
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		log.Printf("Serving HTTP on %s", server.Addr)
+		err = server.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func runWorker(ctx context.Context, workers *sync.WaitGroup, run func(context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		run(ctx)
+	}()
+}
+
+// waitFor waits for the workers to return, or until ctx is done.
+func waitFor(ctx context.Context, workers *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func closeDatabase(db *gorm.DB) {
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to close database: %v", err)
 	}
 }
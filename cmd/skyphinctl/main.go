@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+	"skyphin-api/internal/services"
+	"skyphin-api/pkg/database"
+	"skyphin-api/pkg/password"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// cli holds what the commands share. The database and services are set up
+// on first use, so that commands that fail early never connect.
+type cli struct {
+	jsonOutput bool
+	out        io.Writer
+
+	cfg    config.Config
+	db     *gorm.DB
+	hasher *repositories.TokenHasher
+
+	userRepo *repositories.UserRepository
+	users    *services.UserService
+	auth     *services.AuthService
+	janitor  *services.TokenJanitor
+}
+
+// client identifies changes made with this tool in the audit log.
+var client = models.ClientInfo{UserAgent: "skyphinctl"}
+
+func main() {
+	c := &cli{out: os.Stdout}
+
+	root := &cobra.Command{
+		Use:           "skyphinctl",
+		Short:         "Administer users, tokens and the database of the Skyphin API",
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	root.PersistentFlags().BoolVar(&c.jsonOutput, "json", false, "print results as JSON")
+	root.AddCommand(c.userCommand(), c.tokenCommand(), c.migrateCommand())
+
+	if err := root.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) connect() error {
+	if c.db != nil {
+		return nil
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	db, err := database.NewPostgresDB(cfg.DB)
+	if err != nil {
+		return fmt.Errorf("connecting to database: %w", err)
+	}
+
+	hasher, err := repositories.NewTokenHasher(cfg.Auth.TokenHashKey)
+	if err != nil {
+		return err
+	}
+
+	c.cfg, c.db, c.hasher = cfg, db, hasher
+	return nil
+}
+
+// services builds the services the user and token commands use, wired like
+// the API server's.
+func (c *cli) services() error {
+	if c.auth != nil {
+		return nil
+	}
+	if err := c.connect(); err != nil {
+		return err
+	}
+
+	keyManager, err := services.NewKeyManager(c.cfg.Auth)
+	if err != nil {
+		return fmt.Errorf("loading signing keys: %w", err)
+	}
+
+	passwordHasher, err := password.New(c.cfg.Password)
+	if err != nil {
+		return err
+	}
+	policy, err := password.NewPolicy(c.cfg.Password)
+	if err != nil {
+		return err
+	}
+
+	c.userRepo = repositories.NewUserRepository(c.db)
+	authRepo := repositories.NewAuthRepository(c.db, c.hasher)
+	outboxRepo := repositories.NewOutboxRepository(c.db)
+	tx := repositories.NewTransactor(c.db)
+
+	audit := services.NewAuditService(repositories.NewAuditRepository(c.db))
+	rbac := services.NewRBACService(c.userRepo, repositories.NewRBACRepository(c.db), audit, c.cfg)
+	orgs := services.NewOrganizationService(c.userRepo, repositories.NewOrganizationRepository(c.db, c.hasher), outboxRepo, tx)
+	lockout := services.NewLockoutService(c.userRepo, repositories.NewLockoutRepository(c.db, c.hasher), outboxRepo, tx, audit, c.cfg)
+	passwords := services.NewPasswordService(c.userRepo, passwordHasher, policy, c.cfg)
+
+	c.users = services.NewUserService(c.userRepo, passwords)
+	c.janitor = services.NewTokenJanitor(authRepo, tx, c.cfg)
+	c.auth = services.NewAuthService(c.userRepo, authRepo, repositories.NewSessionRepository(c.db), outboxRepo, tx, rbac, orgs, lockout, passwords, audit, keyManager, c.cfg)
+	return nil
+}
+
+// print writes v as JSON with --json, and otherwise calls text.
+func (c *cli) print(v any, text func(w *tabwriter.Writer)) error {
+	if c.jsonOutput {
+		encoder := json.NewEncoder(c.out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	text(w)
+	return w.Flush()
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+
+	"skyphin-api/internal/migrations"
+	"skyphin-api/internal/repositories"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *cli) migrateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage database migrations",
+	}
+	cmd.AddCommand(c.migrateUpCommand(), c.migrateDownCommand(), c.migrateStatusCommand(), c.migrateDriftCommand())
+	return cmd
+}
+
+func (c *cli) migrator() (*migrations.Migrator, error) {
+	if err := c.connect(); err != nil {
+		return nil, err
+	}
+	return migrations.New(c.db)
+}
+
+func (c *cli) migrateUpCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			migrator, err := c.migrator()
+			if err != nil {
+				return err
+			}
+
+			if err := migrator.Up(); err != nil {
+				return err
+			}
+			if err := repositories.HashStoredTokens(c.db, c.hasher); err != nil {
+				return fmt.Errorf("hashing stored tokens: %w", err)
+			}
+
+			return c.printStatus(migrator)
+		},
+	}
+}
+
+func (c *cli) migrateDownCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "down [n]",
+		Short: "Revert the last n migrations, one by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps := 1
+			if len(args) == 1 {
+				var err error
+				steps, err = strconv.Atoi(args[0])
+				if err != nil || steps < 1 {
+					return fmt.Errorf("invalid number of migrations %q", args[0])
+				}
+			}
+
+			migrator, err := c.migrator()
+			if err != nil {
+				return err
+			}
+
+			if err := migrator.Down(steps); err != nil {
+				return err
+			}
+			return c.printStatus(migrator)
+		},
+	}
+}
+
+func (c *cli) migrateStatusCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List migrations and when they were applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			migrator, err := c.migrator()
+			if err != nil {
+				return err
+			}
+			return c.printStatus(migrator)
+		},
+	}
+}
+
+func (c *cli) migrateDriftCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "drift",
+		Short: "Compare the models with the database, failing on differences",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			migrator, err := c.migrator()
+			if err != nil {
+				return err
+			}
+
+			drift, err := migrator.Drift()
+			if err != nil {
+				return err
+			}
+
+			if drift == nil {
+				drift = []string{}
+			}
+			err = c.print(drift, func(w *tabwriter.Writer) {
+				for _, difference := range drift {
+					fmt.Fprintln(w, difference)
+				}
+			})
+			if err != nil {
+				return err
+			}
+
+			if len(drift) > 0 {
+				return errors.New("the database schema differs from the models")
+			}
+			return nil
+		},
+	}
+}
+
+func (c *cli) printStatus(migrator *migrations.Migrator) error {
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	return c.print(statuses, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+	})
+}
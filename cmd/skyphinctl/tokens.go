@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *cli) tokenCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage tokens",
+	}
+	cmd.AddCommand(c.tokenRevokeCommand(), c.tokenPurgeCommand())
+	return cmd
+}
+
+func (c *cli) tokenRevokeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <id|email|username>",
+		Short: "End every session of a user and revoke all their tokens",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := c.findUser(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := c.auth.RevokeAllTokens(user.ID, client); err != nil {
+				return err
+			}
+
+			return c.print(map[string]any{"user_id": user.ID, "revoked": true}, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Revoked all tokens of user %d\n", user.ID)
+			})
+		},
+	}
+}
+
+func (c *cli) tokenPurgeCommand() *cobra.Command {
+	var batchSize int
+
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete expired tokens, challenges, ended sessions and delivered outbox messages now instead of waiting for the janitor",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if batchSize < 0 {
+				return fmt.Errorf("invalid batch size %d", batchSize)
+			}
+			if err := c.connect(); err != nil {
+				return err
+			}
+			if batchSize > 0 {
+				c.cfg.Janitor.BatchSize = batchSize
+			}
+			if err := c.services(); err != nil {
+				return err
+			}
+
+			purged, err := c.janitor.Purge(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			tables := make([]string, 0, len(purged))
+			for table := range purged {
+				tables = append(tables, table)
+			}
+			sort.Strings(tables)
+
+			return c.print(purged, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "TABLE\tDELETED")
+				for _, table := range tables {
+					fmt.Fprintf(w, "%s\t%d\n", table, purged[table])
+				}
+			})
+		},
+	}
+
+	cmd.Flags().IntVar(&batchSize, "batch-size", 0, "rows to delete per statement, JANITOR_BATCH_SIZE by default")
+	return cmd
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"skyphin-api/internal/models"
+
+	"github.com/spf13/cobra"
+)
+
+func (c *cli) userCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Manage users",
+	}
+	cmd.AddCommand(c.userCreateCommand(), c.userListCommand(), c.userFindCommand(), c.userVerifyCommand(), c.userResetPasswordCommand())
+	return cmd
+}
+
+func (c *cli) userCreateCommand() *cobra.Command {
+	var req models.CreateUserRequest
+	var verified bool
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user",
+		Long: "Create a user. The password is read from standard input, so that it stays out of\n" +
+			"the shell history and the process list. Unless --verified is set, the user is sent\n" +
+			"a verification email.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.services(); err != nil {
+				return err
+			}
+
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && password == "" {
+				return errors.New("no password given")
+			}
+			req.Password = strings.TrimRight(password, "\r\n")
+
+			user, err := c.users.NewUser(&req)
+			if err != nil {
+				return err
+			}
+
+			if verified {
+				user.Verified = true
+				err = c.userRepo.Create(user)
+			} else {
+				err = c.auth.Register(user)
+			}
+			if err != nil {
+				return err
+			}
+
+			return c.printUser(user)
+		},
+	}
+
+	cmd.Flags().StringVar(&req.Username, "username", "", "username (required)")
+	cmd.Flags().StringVar(&req.Email, "email", "", "email address (required)")
+	cmd.Flags().BoolVar(&verified, "verified", false, "mark the user verified instead of sending a verification email")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("email")
+	return cmd
+}
+
+func (c *cli) userListCommand() *cobra.Command {
+	var search string
+	var limit, offset int
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := c.services(); err != nil {
+				return err
+			}
+
+			users, err := c.userRepo.List(search, limit, offset)
+			if err != nil {
+				return err
+			}
+
+			list := make([]*models.User, len(users))
+			for i := range users {
+				list[i] = &users[i]
+			}
+			return c.printUsers(list, list)
+		},
+	}
+
+	cmd.Flags().StringVar(&search, "search", "", "only users whose username or email contains this")
+	cmd.Flags().IntVar(&limit, "limit", 50, "maximum number of users")
+	cmd.Flags().IntVar(&offset, "offset", 0, "number of users to skip")
+	return cmd
+}
+
+func (c *cli) userFindCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "find <id|email|username>",
+		Short: "Show a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := c.findUser(args[0])
+			if err != nil {
+				return err
+			}
+			return c.printUser(user)
+		},
+	}
+}
+
+func (c *cli) userVerifyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify <id|email|username>",
+		Short: "Mark a user verified without a verification code",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := c.findUser(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := c.auth.MarkVerified(user.ID, client); err != nil {
+				return err
+			}
+
+			user.Verified = true
+			return c.printUser(user)
+		},
+	}
+}
+
+func (c *cli) userResetPasswordCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "reset-password <id|email|username>",
+		Short: "Clear a user's password, sign them out and email them a reset link",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			user, err := c.findUser(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := c.auth.ForcePasswordReset(user.ID, client); err != nil {
+				return err
+			}
+
+			return c.print(map[string]any{"user_id": user.ID, "password_reset": true}, func(w *tabwriter.Writer) {
+				fmt.Fprintf(w, "Password of user %d cleared, reset link sent to %s\n", user.ID, user.Email)
+			})
+		},
+	}
+}
+
+// findUser looks a user up by ID, email or username, depending on what ref
+// looks like.
+func (c *cli) findUser(ref string) (*models.User, error) {
+	if err := c.services(); err != nil {
+		return nil, err
+	}
+
+	var user *models.User
+	var err error
+	if id, parseErr := strconv.ParseUint(ref, 10, 0); parseErr == nil {
+		user, err = c.users.GetUserByID(uint(id))
+	} else if strings.Contains(ref, "@") {
+		user, err = c.users.GetUserByEmail(ref)
+	} else {
+		user, err = c.users.GetUserByUsername(ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("user %s not found", ref)
+	}
+	return user, nil
+}
+
+func (c *cli) printUser(user *models.User) error {
+	return c.printUsers(user, []*models.User{user})
+}
+
+// printUsers prints v as JSON, or users as a table.
+func (c *cli) printUsers(v any, users []*models.User) error {
+	return c.print(v, func(w *tabwriter.Writer) {
+		fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tVERIFIED\tMFA\tLOCKED UNTIL\tCREATED")
+		for _, user := range users {
+			locked := "-"
+			if user.LockedUntil != nil {
+				locked = user.LockedUntil.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%t\t%s\t%s\n", user.ID, user.Username, user.Email, user.Verified, user.MFAEnabled, locked, user.CreatedAt.Format("2006-01-02 15:04"))
+		}
+	})
+}
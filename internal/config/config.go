@@ -1,23 +1,202 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server ServerConfig   `mapstructure:",squash"`
-	DB     DatabaseConfig `mapstructure:",squash"`
-	Auth   AuthConfig     `mapstructure:",squash"`
+	Server     ServerConfig     `mapstructure:",squash"`
+	DB         DatabaseConfig   `mapstructure:",squash"`
+	Auth       AuthConfig       `mapstructure:",squash"`
+	WebAuthn   WebAuthnConfig   `mapstructure:",squash"`
+	Mail       MailConfig       `mapstructure:",squash"`
+	Outbox     OutboxConfig     `mapstructure:",squash"`
+	Janitor    JanitorConfig    `mapstructure:",squash"`
+	RateLimit  RateLimitConfig  `mapstructure:",squash"`
+	Password   PasswordConfig   `mapstructure:",squash"`
+	OAuth      OAuthConfig      `mapstructure:",squash"`
+	Federation FederationConfig `mapstructure:",squash"`
 }
 
 type AuthConfig struct {
 	AccessTokenSecret        string `mapstructure:"ACCESS_TOKEN_SECRET"`
 	AccessTokenExpiryMinutes int    `mapstructure:"ACCESS_TOKEN_EXPIRY_MINUTES"`
 	RefreshTokenExpiryDays   int    `mapstructure:"REFRESH_TOKEN_EXPIRY_DAYS"`
+	// How long each instance caches whether an access token is still valid,
+	// 30 seconds by default. The cache is per instance: a logout or session
+	// revocation takes effect at once on the instance that handled it, and
+	// on the others only after up to this long. Keep it short.
+	TokenCacheTTLSeconds int `mapstructure:"TOKEN_CACHE_TTL_SECONDS"`
+	// Secret key for the digests that opaque tokens are stored under.
+	// Changing it invalidates every outstanding token.
+	TokenHashKey string `mapstructure:"TOKEN_HASH_KEY"`
+	// Comma-separated PEM private keys (RSA, ECDSA or Ed25519) used to sign
+	// JWTs. Add a file to rotate: the newest key signs once it has been
+	// published for a few minutes.
+	SigningKeyFiles []string `mapstructure:"SIGNING_KEY_FILES"`
+	// Keep accepting HS256 tokens signed with ACCESS_TOKEN_SECRET once
+	// signing keys are configured. Only set this for the lifetime of the
+	// tokens issued before the switch.
+	AcceptLegacyHS256 bool   `mapstructure:"ACCEPT_LEGACY_HS256"`
+	MFAIssuer         string `mapstructure:"MFA_ISSUER"`
+	// Users granted the admin role at startup.
+	AdminUserIDs []uint `mapstructure:"ADMIN_USER_IDS"`
+	// Accounts lock after LOGIN_MAX_FAILURES failed logins within the window.
+	LoginMaxFailures          int `mapstructure:"LOGIN_MAX_FAILURES"`
+	LoginFailureWindowMinutes int `mapstructure:"LOGIN_FAILURE_WINDOW_MINUTES"`
+	LockoutDurationMinutes    int `mapstructure:"LOCKOUT_DURATION_MINUTES"`
+	// Failures from one IP, across all accounts, before it is throttled.
+	LoginMaxIPFailures    int `mapstructure:"LOGIN_MAX_IP_FAILURES"`
+	LoginDelayBaseSeconds int `mapstructure:"LOGIN_DELAY_BASE_SECONDS"`
+	LoginDelayMaxSeconds  int `mapstructure:"LOGIN_DELAY_MAX_SECONDS"`
+	// Magic links and their codes expire after 10 minutes and allow 5 code
+	// attempts by default.
+	MagicLinkTTLMinutes  int `mapstructure:"MAGIC_LINK_TTL_MINUTES"`
+	MagicLinkMaxAttempts int `mapstructure:"MAGIC_LINK_MAX_ATTEMPTS"`
+}
+
+type WebAuthnConfig struct {
+	RPID          string   `mapstructure:"WEBAUTHN_RP_ID"`
+	RPDisplayName string   `mapstructure:"WEBAUTHN_RP_DISPLAY_NAME"`
+	RPOrigins     []string `mapstructure:"WEBAUTHN_RP_ORIGINS"`
+}
+
+type MailConfig struct {
+	Transport    string `mapstructure:"MAIL_TRANSPORT"`
+	From         string `mapstructure:"MAIL_FROM"`
+	FileDir      string `mapstructure:"MAIL_FILE_DIR"`
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     int    `mapstructure:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	SMTPTLS      string `mapstructure:"SMTP_TLS"`
+}
+
+type OutboxConfig struct {
+	PollIntervalSeconds int `mapstructure:"OUTBOX_POLL_INTERVAL_SECONDS"`
+	BatchSize           int `mapstructure:"OUTBOX_BATCH_SIZE"`
+	MaxAttempts         int `mapstructure:"OUTBOX_MAX_ATTEMPTS"`
+	BackoffBaseSeconds  int `mapstructure:"OUTBOX_BACKOFF_BASE_SECONDS"`
+	BackoffMaxSeconds   int `mapstructure:"OUTBOX_BACKOFF_MAX_SECONDS"`
+	// Domain events are POSTed here as JSON. When empty they are only logged.
+	EventsWebhookURL string `mapstructure:"EVENTS_WEBHOOK_URL"`
+}
+
+type JanitorConfig struct {
+	IntervalSeconds int `mapstructure:"JANITOR_INTERVAL_SECONDS"`
+	// Rows deleted per statement, to keep locks and transactions short.
+	BatchSize int `mapstructure:"JANITOR_BATCH_SIZE"`
+}
+
+type PasswordConfig struct {
+	Algorithm         string `mapstructure:"PASSWORD_HASH_ALGORITHM"`
+	Argon2MemoryKiB   int    `mapstructure:"ARGON2_MEMORY_KIB"`
+	Argon2Iterations  int    `mapstructure:"ARGON2_ITERATIONS"`
+	Argon2Parallelism int    `mapstructure:"ARGON2_PARALLELISM"`
+	BcryptCost        int    `mapstructure:"BCRYPT_COST"`
+	// Optional server-side secret mixed into argon2id hashes.
+	Pepper string `mapstructure:"PASSWORD_PEPPER"`
+	// Comma-separated peppers that PASSWORD_PEPPER replaced. Hashes made
+	// with them keep verifying and are rehashed with the current pepper on
+	// the next login, so a pepper can be dropped from here once no hash
+	// uses it.
+	PreviousPeppers []string `mapstructure:"PASSWORD_PREVIOUS_PEPPERS"`
+	MinLength       int      `mapstructure:"PASSWORD_MIN_LENGTH"`
+	MaxLength       int      `mapstructure:"PASSWORD_MAX_LENGTH"`
+	// Newline-separated passwords rejected in addition to the built-in list.
+	BannedFile string `mapstructure:"PASSWORD_BANNED_FILE"`
+	// Directory of Have I Been Pwned range files (e.g. 5BAA6.txt).
+	BreachedDir string `mapstructure:"PASSWORD_BREACHED_DIR"`
+	// Number of previous passwords that cannot be reused.
+	HistorySize int `mapstructure:"PASSWORD_HISTORY_SIZE"`
+}
+
+type RateLimitConfig struct {
+	Backend  string `mapstructure:"RATE_LIMIT_BACKEND"`
+	RedisURL string `mapstructure:"REDIS_URL"`
+	// Comma-separated name=requests/period@key entries overriding the
+	// built-in policies, e.g. "login=10/1m@ip,reset_email=3/1h@field:email".
+	Policies []string `mapstructure:"RATE_LIMIT_POLICIES"`
+}
+
+type OAuthConfig struct {
+	// Base URL of this API, used as the issuer of tokens issued to OAuth
+	// clients, in the OpenID Connect discovery document and for federated
+	// login callbacks. Defaults to PUBLIC_URL, which only works when the web
+	// app and the API share an origin.
+	Issuer string `mapstructure:"OAUTH_ISSUER"`
+	// Page of the web app that shows the OAuth consent screen. Defaults to
+	// PUBLIC_URL/oauth/consent.
+	ConsentURL string `mapstructure:"OAUTH_CONSENT_URL"`
+	// Lifetime of authorization codes, 5 minutes by default.
+	AuthorizationCodeTTLSeconds int `mapstructure:"OAUTH_CODE_TTL_SECONDS"`
+}
+
+type FederationConfig struct {
+	// Comma-separated names of external identity providers, e.g.
+	// "google,github". Each is configured by FEDERATED_<NAME>_* variables.
+	ProviderNames []string                  `mapstructure:"FEDERATED_PROVIDERS"`
+	Providers     []FederatedProviderConfig `mapstructure:"-"`
+}
+
+// FederatedProviderConfig describes an external identity provider. OpenID
+// Connect providers only need an issuer, as their endpoints are discovered.
+// Plain OAuth2 providers such as GitHub need the three URLs and, usually,
+// the claim names of their user info response.
+type FederatedProviderConfig struct {
+	Name               string
+	Issuer             string // FEDERATED_<NAME>_ISSUER
+	ClientID           string // FEDERATED_<NAME>_CLIENT_ID
+	ClientSecret       string // FEDERATED_<NAME>_CLIENT_SECRET
+	Scopes             []string
+	AuthURL            string // FEDERATED_<NAME>_AUTH_URL
+	TokenURL           string // FEDERATED_<NAME>_TOKEN_URL
+	UserInfoURL        string // FEDERATED_<NAME>_USERINFO_URL
+	SubjectClaim       string
+	EmailClaim         string
+	EmailVerifiedClaim string
+	UsernameClaim      string
+	// Treat emails from a provider without an email_verified claim as
+	// verified. Only set this for providers that verify addresses.
+	TrustEmail bool
 }
 
 type ServerConfig struct {
 	Address string `mapstructure:"ADDRESS"`
+	// Base URL of the web app, not of this API. Emails link to its pages,
+	// which take the token from the query string and POST it to the API:
+	//
+	//	/verify?token=           POST /verify {"token"}
+	//	/reset-password?token=   POST /reset-password {"token", "password"}
+	//	/unlock?token=           POST /unlock {"token"}
+	//	/login/magic-link?token= POST /login/magic-link/verify {"token"}
+	//	/invitations?token=      POST /v1/invitations/accept {"token"}
+	//
+	// The magic link POST must carry the cookie set when the link was asked
+	// for. Federated logins finish at /login/federated/complete and OAuth
+	// consent is asked at /oauth/consent. Set OAUTH_ISSUER when the API is
+	// served from another origin.
+	PublicURL string `mapstructure:"PUBLIC_URL"`
+	// Comma-separated addresses or CIDRs of the reverse proxies whose
+	// X-Forwarded-For header is believed. By default none are, and the
+	// client IP used for rate limits and lockouts is the peer address.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+	// Prometheus metrics are served on this address. When empty they are
+	// not served.
+	MetricsAddress      string `mapstructure:"METRICS_ADDRESS"`
+	ReadTimeoutSeconds  int    `mapstructure:"SERVER_READ_TIMEOUT_SECONDS"`
+	WriteTimeoutSeconds int    `mapstructure:"SERVER_WRITE_TIMEOUT_SECONDS"`
+	IdleTimeoutSeconds  int    `mapstructure:"SERVER_IDLE_TIMEOUT_SECONDS"`
+	// 0 uses the net/http default of 1 MB.
+	MaxHeaderBytes int `mapstructure:"SERVER_MAX_HEADER_BYTES"`
+	// How long in-flight requests get to finish on SIGTERM.
+	ShutdownTimeoutSeconds int `mapstructure:"SERVER_SHUTDOWN_TIMEOUT_SECONDS"`
+	// Serve HTTPS with this certificate and key when both are set.
+	TLSCertFile string `mapstructure:"TLS_CERT_FILE"`
+	TLSKeyFile  string `mapstructure:"TLS_KEY_FILE"`
 }
 
 type DatabaseConfig struct {
@@ -27,6 +206,9 @@ type DatabaseConfig struct {
 	Password string `mapstructure:"DB_PASSWORD"`
 	Name     string `mapstructure:"DB_NAME"`
 	SSLMode  string `mapstructure:"DB_SSLMODE"`
+	// Leave migrations to skyphinctl migrate. Startup then fails while
+	// any are pending.
+	SkipMigrations bool `mapstructure:"DB_SKIP_MIGRATIONS"`
 }
 
 func LoadConfig() (Config, error) {
@@ -43,5 +225,38 @@ func LoadConfig() (Config, error) {
 		return Config{}, err
 	}
 
+	for _, name := range config.Federation.ProviderNames {
+		if name = strings.TrimSpace(name); name != "" {
+			config.Federation.Providers = append(config.Federation.Providers, loadFederatedProvider(name))
+		}
+	}
+
 	return config, nil
 }
+
+func loadFederatedProvider(name string) FederatedProviderConfig {
+	key := func(suffix string) string {
+		return fmt.Sprintf("FEDERATED_%s_%s", strings.ToUpper(name), suffix)
+	}
+
+	provider := FederatedProviderConfig{
+		Name:               name,
+		Issuer:             viper.GetString(key("ISSUER")),
+		ClientID:           viper.GetString(key("CLIENT_ID")),
+		ClientSecret:       viper.GetString(key("CLIENT_SECRET")),
+		AuthURL:            viper.GetString(key("AUTH_URL")),
+		TokenURL:           viper.GetString(key("TOKEN_URL")),
+		UserInfoURL:        viper.GetString(key("USERINFO_URL")),
+		SubjectClaim:       viper.GetString(key("SUBJECT_CLAIM")),
+		EmailClaim:         viper.GetString(key("EMAIL_CLAIM")),
+		EmailVerifiedClaim: viper.GetString(key("EMAIL_VERIFIED_CLAIM")),
+		UsernameClaim:      viper.GetString(key("USERNAME_CLAIM")),
+		TrustEmail:         viper.GetBool(key("TRUST_EMAIL")),
+	}
+	for _, scope := range strings.Split(viper.GetString(key("SCOPES")), ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			provider.Scopes = append(provider.Scopes, scope)
+		}
+	}
+	return provider
+}
@@ -0,0 +1,30 @@
+package config
+
+import "time"
+
+// The settings below are optional: zero or a negative value means the
+// default.
+
+// SecondsOr returns seconds as a duration, or fallback if it is not set.
+func SecondsOr(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Second * time.Duration(seconds)
+}
+
+// MinutesOr returns minutes as a duration, or fallback if it is not set.
+func MinutesOr(minutes int, fallback time.Duration) time.Duration {
+	if minutes <= 0 {
+		return fallback
+	}
+	return time.Minute * time.Duration(minutes)
+}
+
+// IntOr returns value, or fallback if it is not set.
+func IntOr(value int, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyController struct {
+	service *services.APIKeyService
+}
+
+func NewAPIKeyController(service *services.APIKeyService) *APIKeyController {
+	return &APIKeyController{service: service}
+}
+
+// CreateAPIKey returns the new key in plain text. It cannot be shown again.
+func (c *APIKeyController) CreateAPIKey(ctx *gin.Context) {
+	if _, ok := ctx.Get("api_key_id"); ok {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot create API keys"})
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plain, err := c.service.Create(ctx.GetUint("user_id"), &req, clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"api_key": key, "key": plain})
+}
+
+func (c *APIKeyController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := c.service.List(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, keys)
+}
+
+func (c *APIKeyController) RevokeAPIKey(ctx *gin.Context) {
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.service.Revoke(ctx.GetUint("user_id"), id, clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditController struct {
+	service *services.AuditService
+}
+
+func NewAuditController(service *services.AuditService) *AuditController {
+	return &AuditController{service: service}
+}
+
+// ListEvents returns audit events matching the query filters, newest first.
+func (c *AuditController) ListEvents(ctx *gin.Context) {
+	var filter models.AuditEventFilter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	events, err := c.service.List(&filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, events)
+}
+
+// ExportEvents streams every matching audit event as JSON lines.
+func (c *AuditController) ExportEvents(ctx *gin.Context) {
+	var filter models.AuditEventFilter
+	if err := ctx.ShouldBindQuery(&filter); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.Header("Content-Type", "application/x-ndjson")
+	ctx.Header("Content-Disposition", `attachment; filename="audit-events.jsonl"`)
+	ctx.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure can only cut the stream short.
+	if err := c.service.Export(&filter, ctx.Writer); err != nil {
+		log.Printf("Failed to export audit events: %v", err)
+	}
+}
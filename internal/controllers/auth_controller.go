@@ -1,24 +1,26 @@
 package controllers
 
 import (
+	"errors"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
 
 	"skyphin-api/internal/models"
-	"skyphin-api/internal/repositories"
 	"skyphin-api/internal/services"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthController struct {
-	authService  *services.AuthService
-	userService  *services.UserService
-	emailService *services.EmailService
-	userRepo     *repositories.UserRepository
+	authService *services.AuthService
+	userService *services.UserService
+	mfaService  *services.MFAService
 }
 
-func NewAuthController(authService *services.AuthService, userService *services.UserService) *AuthController {
-	return &AuthController{authService: authService, userService: userService}
+func NewAuthController(authService *services.AuthService, userService *services.UserService, mfaService *services.MFAService) *AuthController {
+	return &AuthController{authService: authService, userService: userService, mfaService: mfaService}
 }
 
 func (c *AuthController) Register(ctx *gin.Context) {
@@ -28,25 +30,25 @@ func (c *AuthController) Register(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.userService.CreateUser(&req); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	user, err := c.userRepo.FindByEmail(req.Email)
+	user, err := c.userService.NewUser(&req)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		if fields, ok := passwordFieldErrors(err); ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the requirements", "fields": fields})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	token, err := c.authService.GenerateVerificationToken(user.ID)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate verification token"})
+	if err := c.authService.Register(user); err != nil {
+		if errors.Is(err, services.ErrUsernameTaken) || errors.Is(err, services.ErrEmailTaken) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
 		return
 	}
 
-	c.emailService.SendVerificationEmail(user.Email, token)
-
 	ctx.JSON(http.StatusCreated, gin.H{"message": "User registered. Verification code sent to your email."})
 }
 
@@ -57,7 +59,7 @@ func (c *AuthController) Verify(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.authService.VerifyAccount(req.Token); err != nil {
+	if err := c.authService.VerifyAccount(req.Token, clientInfo(ctx)); err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
@@ -72,8 +74,17 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.authService.Login(&req)
-	if err != nil {
+	user, err := c.authService.Login(&req, clientInfo(ctx))
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		ctx.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrAccountLocked):
+		ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
@@ -83,7 +94,40 @@ func (c *AuthController) Login(ctx *gin.Context) {
 		return
 	}
 
-	accessToken, refreshToken, err := c.authService.GenerateTokens(user)
+	if user.MFAEnabled {
+		mfaToken, err := c.mfaService.IssueChallenge(user.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	accessToken, refreshToken, err := c.authService.CompleteLogin(user, "password", clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+}
+
+func (c *AuthController) LoginMFA(ctx *gin.Context) {
+	var req models.MFALoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.mfaService.CompleteChallenge(&req, clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessToken, refreshToken, err := c.authService.CompleteLogin(user, "mfa", clientInfo(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -99,13 +143,42 @@ func (c *AuthController) Refresh(ctx *gin.Context) {
 		return
 	}
 
-	accessToken, err := c.authService.RefreshAccessToken(req.RefreshToken)
+	accessToken, refreshToken, err := c.authService.RefreshAccessToken(req.RefreshToken, clientInfo(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken})
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+}
+
+func (c *AuthController) Logout(ctx *gin.Context) {
+	var req models.LogoutRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) { // The body is optional.
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.authService.Logout(ctx.GetUint("user_id"), ctx.GetUint("session_id"), ctx.GetString("access_token"), req.RefreshToken); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+func (c *AuthController) LogoutAll(ctx *gin.Context) {
+	if err := c.authService.LogoutAll(ctx.GetUint("user_id")); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged out of all sessions"})
+}
+
+func (c *AuthController) JWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(services.JWKSMaxAge.Seconds())))
+	ctx.JSON(http.StatusOK, c.authService.JWKS())
 }
 
 func (c *AuthController) ResetPasswordRequest(ctx *gin.Context) {
@@ -115,14 +188,11 @@ func (c *AuthController) ResetPasswordRequest(ctx *gin.Context) {
 		return
 	}
 
-	token, err := c.authService.GenerateResetToken(req.Email)
-	if err != nil {
+	if err := c.authService.RequestPasswordReset(req.Email, clientInfo(ctx)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.emailService.SendVerificationEmail(req.Email, token)
-
 	ctx.JSON(http.StatusOK, gin.H{"message": "Password reset email sent"})
 }
 
@@ -133,10 +203,23 @@ func (c *AuthController) ResetPassword(ctx *gin.Context) {
 		return
 	}
 
-	if err := c.authService.ResetPassword(&req); err != nil {
+	if err := c.authService.ResetPassword(&req, clientInfo(ctx)); err != nil {
+		if fields, ok := passwordFieldErrors(err); ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the requirements", "fields": fields})
+			return
+		}
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
 }
+
+// clientInfo describes the caller for the audit log.
+func clientInfo(ctx *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+		RequestID: ctx.GetString("request_id"),
+	}
+}
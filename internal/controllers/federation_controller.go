@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FederationController struct {
+	federationService *services.FederationService
+	authService       *services.AuthService
+	mfaService        *services.MFAService
+}
+
+func NewFederationController(federationService *services.FederationService, authService *services.AuthService, mfaService *services.MFAService) *FederationController {
+	return &FederationController{federationService: federationService, authService: authService, mfaService: mfaService}
+}
+
+func (c *FederationController) ListProviders(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"providers": c.federationService.Providers()})
+}
+
+func (c *FederationController) Begin(ctx *gin.Context) {
+	authURL, state, err := c.federationService.Begin(ctx.Request.Context(), ctx.Param("provider"))
+	if errors.Is(err, services.ErrUnknownProvider) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The provider redirects back with a top-level GET, which Lax cookies
+	// survive.
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(services.FederatedLoginStateName, state, 600, "/", "", c.federationService.SecureCookies(), true)
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback is where the provider sends the browser back to. It hands the
+// result to the web app, which exchanges the login code for tokens.
+func (c *FederationController) Callback(ctx *gin.Context) {
+	cookieState, _ := ctx.Cookie(services.FederatedLoginStateName)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(services.FederatedLoginStateName, "", -1, "/", "", c.federationService.SecureCookies(), true)
+
+	if providerErr := ctx.Query("error"); providerErr != "" {
+		ctx.Redirect(http.StatusFound, c.federationService.FrontendURL("", errors.New("sign-in was cancelled")))
+		return
+	}
+
+	loginCode, err := c.federationService.Callback(ctx.Request.Context(), ctx.Param("provider"), ctx.Query("code"), ctx.Query("state"), cookieState, clientInfo(ctx))
+	ctx.Redirect(http.StatusFound, c.federationService.FrontendURL(loginCode, err))
+}
+
+func (c *FederationController) Exchange(ctx *gin.Context) {
+	var req models.FederatedLoginExchangeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.federationService.Exchange(req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user.MFAEnabled {
+		mfaToken, err := c.mfaService.IssueChallenge(user.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	accessToken, refreshToken, err := c.authService.CompleteLogin(user, "federated", clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+}
+
+func (c *FederationController) ListIdentities(ctx *gin.Context) {
+	identities, err := c.federationService.ListIdentities(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, identities)
+}
+
+func (c *FederationController) Unlink(ctx *gin.Context) {
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.federationService.Unlink(ctx.GetUint("user_id"), id, clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Login unlinked"})
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LockoutController struct {
+	service *services.LockoutService
+}
+
+func NewLockoutController(service *services.LockoutService) *LockoutController {
+	return &LockoutController{service: service}
+}
+
+// Unlock lifts a lock with the token from the account locked email.
+func (c *LockoutController) Unlock(ctx *gin.Context) {
+	var req models.UnlockAccountRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.UnlockWithToken(req.Token, clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
+
+func (c *LockoutController) UnlockUser(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if err := c.service.Unlock(uint(id), ctx.GetUint("user_id"), clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Account unlocked"})
+}
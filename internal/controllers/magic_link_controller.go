@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MagicLinkController struct {
+	magicLinkService *services.MagicLinkService
+	authService      *services.AuthService
+	mfaService       *services.MFAService
+}
+
+func NewMagicLinkController(magicLinkService *services.MagicLinkService, authService *services.AuthService, mfaService *services.MFAService) *MagicLinkController {
+	return &MagicLinkController{magicLinkService: magicLinkService, authService: authService, mfaService: mfaService}
+}
+
+func (c *MagicLinkController) Request(ctx *gin.Context) {
+	var req models.MagicLinkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	binding, err := c.magicLinkService.Request(req.Email, clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(services.MagicLinkBindingCookie, binding, c.magicLinkService.CookieMaxAge(), "/", "", c.magicLinkService.SecureCookies(), true)
+	ctx.JSON(http.StatusOK, gin.H{"message": "If an account uses this email, a sign-in link is on its way"})
+}
+
+func (c *MagicLinkController) Verify(ctx *gin.Context) {
+	var req models.MagicLinkVerifyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	binding, _ := ctx.Cookie(services.MagicLinkBindingCookie)
+	user, err := c.magicLinkService.Verify(&req, binding, clientInfo(ctx))
+	switch {
+	case errors.Is(err, services.ErrAccountLocked):
+		ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(services.MagicLinkBindingCookie, "", -1, "/", "", c.magicLinkService.SecureCookies(), true)
+
+	if user.MFAEnabled {
+		mfaToken, err := c.mfaService.IssueChallenge(user.ID)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.JSON(http.StatusOK, gin.H{"mfa_required": true, "mfa_token": mfaToken})
+		return
+	}
+
+	accessToken, refreshToken, err := c.authService.CompleteLogin(user, "magic_link", clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAController struct {
+	service *services.MFAService
+}
+
+func NewMFAController(service *services.MFAService) *MFAController {
+	return &MFAController{service: service}
+}
+
+func (c *MFAController) EnrollTOTP(ctx *gin.Context) {
+	enrollment, err := c.service.EnrollTOTP(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, enrollment)
+}
+
+func (c *MFAController) ConfirmTOTP(ctx *gin.Context) {
+	var req models.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := c.service.ConfirmTOTP(ctx.GetUint("user_id"), req.Code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (c *MFAController) DisableTOTP(ctx *gin.Context) {
+	var req models.DisableMFARequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.DisableTOTP(ctx.GetUint("user_id"), &req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "MFA disabled"})
+}
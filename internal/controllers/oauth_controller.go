@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthController struct {
+	service *services.OAuthService
+}
+
+func NewOAuthController(service *services.OAuthService) *OAuthController {
+	return &OAuthController{service: service}
+}
+
+// Authorize starts the authorization code flow by sending the browser to the
+// consent screen of the web app. Problems with the client or redirect URI are
+// shown to the user; anything else is reported back to the client.
+func (c *OAuthController) Authorize(ctx *gin.Context) {
+	var req models.AuthorizeRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	oauthClient, _, err := c.service.ValidateAuthorization(&req)
+	if oauthClient == nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+	if err != nil {
+		ctx.Redirect(http.StatusFound, c.service.AuthorizationErrorURL(&req, err))
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, c.service.ConsentScreenURL(&req))
+}
+
+// ConsentDetails tells the consent screen which client is asking for what.
+func (c *OAuthController) ConsentDetails(ctx *gin.Context) {
+	if !requireInteractiveUser(ctx) {
+		return
+	}
+
+	var req models.AuthorizeRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	details, err := c.service.ConsentDetails(ctx.GetUint("user_id"), &req)
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, details)
+}
+
+// Decide records the user's answer on the consent screen and returns the URL
+// the web app should send the browser to.
+func (c *OAuthController) Decide(ctx *gin.Context) {
+	if !requireInteractiveUser(ctx) {
+		return
+	}
+
+	var decision models.AuthorizeDecision
+	if err := ctx.ShouldBindJSON(&decision); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	redirectURI, err := c.service.Authorize(ctx.GetUint("user_id"), &decision, clientInfo(ctx))
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"redirect_uri": redirectURI})
+}
+
+func (c *OAuthController) Token(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Pragma", "no-cache")
+
+	var req models.TokenRequest
+	if err := ctx.ShouldBind(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	clientID, clientSecret := req.ClientID, req.ClientSecret
+	username, password, basic := ctx.Request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1: both parts are form-encoded first.
+		clientID, _ = url.QueryUnescape(username)
+		clientSecret, _ = url.QueryUnescape(password)
+	}
+
+	response, err := c.service.Token(&req, clientID, clientSecret, clientInfo(ctx))
+	if err != nil {
+		var oauthErr *services.OAuthError
+		if basic && errors.As(err, &oauthErr) && oauthErr.Code == "invalid_client" {
+			ctx.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// Introspect tells a client granted the introspect scope whether a token is
+// active, following RFC 7662.
+func (c *OAuthController) Introspect(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "no-store")
+
+	token := ctx.PostForm("token")
+	if token == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	response, err := c.service.Introspect(token)
+	if err != nil {
+		writeOAuthError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// CreateClient returns the client secret, if any, in plain text. It cannot be
+// shown again.
+func (c *OAuthController) CreateClient(ctx *gin.Context) {
+	var req models.CreateOAuthClientRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	oauthClient, secret, err := c.service.CreateClient(&req, ctx.GetUint("user_id"), clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{"client": oauthClient}
+	if secret != "" {
+		response["client_secret"] = secret
+	}
+	ctx.JSON(http.StatusCreated, response)
+}
+
+func (c *OAuthController) ListClients(ctx *gin.Context) {
+	clients, err := c.service.ListClients()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, clients)
+}
+
+func (c *OAuthController) DeleteClient(ctx *gin.Context) {
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.service.DeleteClient(id, ctx.GetUint("user_id"), clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Client deleted"})
+}
+
+// requireInteractiveUser rejects requests made with an API key, which must not
+// be able to grant applications access on the user's behalf.
+func requireInteractiveUser(ctx *gin.Context) bool {
+	if _, ok := ctx.Get("api_key_id"); ok {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Not allowed with an API key"})
+		return false
+	}
+	return true
+}
+
+func writeOAuthError(ctx *gin.Context, err error) {
+	var oauthErr *services.OAuthError
+	if !errors.As(err, &oauthErr) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	ctx.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"net/http"
+
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCController struct {
+	service *services.OIDCService
+}
+
+func NewOIDCController(service *services.OIDCService) *OIDCController {
+	return &OIDCController{service: service}
+}
+
+func (c *OIDCController) Discovery(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.service.Discovery())
+}
+
+func (c *OIDCController) UserInfo(ctx *gin.Context) {
+	claims, err := c.service.UserInfo(ctx.GetUint("user_id"), ctx.GetString("scope"))
+	if err != nil {
+		ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, claims)
+}
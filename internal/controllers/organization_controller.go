@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationController struct {
+	service     *services.OrganizationService
+	authService *services.AuthService
+}
+
+func NewOrganizationController(service *services.OrganizationService, authService *services.AuthService) *OrganizationController {
+	return &OrganizationController{service: service, authService: authService}
+}
+
+func (c *OrganizationController) CreateOrganization(ctx *gin.Context) {
+	var req models.CreateOrganizationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	org, err := c.service.CreateOrganization(ctx.GetUint("user_id"), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, org)
+}
+
+func (c *OrganizationController) ListOrganizations(ctx *gin.Context) {
+	memberships, err := c.service.ListMemberships(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, memberships)
+}
+
+// ActivateOrganization makes the organization the user's active tenant and
+// returns an access token carrying it.
+func (c *OrganizationController) ActivateOrganization(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+
+	userID := ctx.GetUint("user_id")
+	if err := c.service.SetActiveOrganization(userID, &orgID); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	accessToken, err := c.authService.IssueAccessToken(userID, ctx.GetUint("session_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken})
+}
+
+func (c *OrganizationController) ListMembers(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+
+	members, err := c.service.ListMembers(orgID, ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, members)
+}
+
+func (c *OrganizationController) UpdateMember(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+	userID, ok := uintParam(ctx, "user_id")
+	if !ok {
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.UpdateMemberRole(orgID, ctx.GetUint("user_id"), userID, req.Role); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Member updated"})
+}
+
+func (c *OrganizationController) RemoveMember(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+	userID, ok := uintParam(ctx, "user_id")
+	if !ok {
+		return
+	}
+
+	if err := c.service.RemoveMember(orgID, ctx.GetUint("user_id"), userID); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+func (c *OrganizationController) InviteMember(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+
+	var req models.InviteMemberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation, err := c.service.InviteMember(orgID, ctx.GetUint("user_id"), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, invitation)
+}
+
+func (c *OrganizationController) ListInvitations(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+
+	invitations, err := c.service.ListInvitations(orgID, ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *OrganizationController) RevokeInvitation(ctx *gin.Context) {
+	orgID, ok := uintParam(ctx, "org_id")
+	if !ok {
+		return
+	}
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.service.RevokeInvitation(orgID, ctx.GetUint("user_id"), id); err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Invitation revoked"})
+}
+
+func (c *OrganizationController) ListMyInvitations(ctx *gin.Context) {
+	invitations, err := c.service.ListUserInvitations(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, invitations)
+}
+
+func (c *OrganizationController) AcceptInvitation(ctx *gin.Context) {
+	var req models.InvitationResponseRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	membership, err := c.service.AcceptInvitation(ctx.GetUint("user_id"), req.Token)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, membership)
+}
+
+func (c *OrganizationController) DeclineInvitation(ctx *gin.Context) {
+	var req models.InvitationResponseRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.service.DeclineInvitation(ctx.GetUint("user_id"), req.Token); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Invitation declined"})
+}
+
+// uintParam parses a numeric path parameter and writes a 400 response if it
+// is invalid.
+func uintParam(ctx *gin.Context, name string) (uint, bool) {
+	value, err := strconv.ParseUint(ctx.Param(name), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return 0, false
+	}
+	return uint(value), true
+}
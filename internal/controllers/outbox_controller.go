@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OutboxController struct {
+	dispatcher *services.OutboxDispatcher
+}
+
+func NewOutboxController(dispatcher *services.OutboxDispatcher) *OutboxController {
+	return &OutboxController{dispatcher: dispatcher}
+}
+
+// ListMessages shows outbox messages, dead ones by default.
+func (c *OutboxController) ListMessages(ctx *gin.Context) {
+	status := ctx.DefaultQuery("status", models.OutboxStatusDead)
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+		return
+	}
+	offset, err := strconv.Atoi(ctx.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+		return
+	}
+
+	messages, err := c.dispatcher.ListMessages(status, limit, offset)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, messages)
+}
+
+func (c *OutboxController) Retry(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	if err := c.dispatcher.Requeue(uint(id)); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Dead message not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Message requeued"})
+}
@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionController struct {
+	authService *services.AuthService
+}
+
+func NewSessionController(authService *services.AuthService) *SessionController {
+	return &SessionController{authService: authService}
+}
+
+func (c *SessionController) ListSessions(ctx *gin.Context) {
+	sessions, err := c.authService.ListSessions(ctx.GetUint("user_id"), ctx.GetUint("session_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+func (c *SessionController) RevokeSession(ctx *gin.Context) {
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.authService.RevokeSession(ctx.GetUint("user_id"), id, clientInfo(ctx)); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
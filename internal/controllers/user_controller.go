@@ -1,11 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"skyphin-api/internal/models"
 	"skyphin-api/internal/services"
+	"skyphin-api/pkg/password"
 
 	"github.com/gin-gonic/gin"
 )
@@ -26,6 +28,10 @@ func (c *UserController) CreateUser(ctx *gin.Context) {
 	}
 
 	if err := c.service.CreateUser(&req); err != nil {
+		if fields, ok := passwordFieldErrors(err); ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the requirements", "fields": fields})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -48,3 +54,17 @@ func (c *UserController) GetUserById(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, user)
 }
+
+// passwordFieldErrors converts a password policy violation into field errors.
+func passwordFieldErrors(err error) ([]models.FieldError, bool) {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return nil, false
+	}
+
+	fields := make([]models.FieldError, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		fields[i] = models.FieldError{Field: "password", Code: v.Code, Message: v.Message}
+	}
+	return fields, true
+}
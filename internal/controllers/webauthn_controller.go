@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnController struct {
+	service     *services.WebAuthnService
+	authService *services.AuthService
+}
+
+func NewWebAuthnController(service *services.WebAuthnService, authService *services.AuthService) *WebAuthnController {
+	return &WebAuthnController{service: service, authService: authService}
+}
+
+func (c *WebAuthnController) BeginRegistration(ctx *gin.Context) {
+	token, options, err := c.service.BeginRegistration(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"session_token": token, "options": options})
+}
+
+func (c *WebAuthnController) FinishRegistration(ctx *gin.Context) {
+	var req models.WebAuthnFinishRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := c.service.FinishRegistration(ctx.GetUint("user_id"), &req)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, credential)
+}
+
+func (c *WebAuthnController) BeginLogin(ctx *gin.Context) {
+	var req models.WebAuthnLoginBeginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) { // The body is optional.
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, options, err := c.service.BeginLogin(req.Email)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"session_token": token, "options": options})
+}
+
+func (c *WebAuthnController) FinishLogin(ctx *gin.Context) {
+	var req models.WebAuthnFinishRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.service.FinishLogin(&req, clientInfo(ctx))
+	switch {
+	case errors.Is(err, services.ErrAccountLocked):
+		ctx.JSON(http.StatusLocked, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !user.Verified {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Account not verified"})
+		return
+	}
+
+	accessToken, refreshToken, err := c.authService.CompleteLogin(user, "webauthn", clientInfo(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"access_token": accessToken, "refresh_token": refreshToken})
+}
+
+func (c *WebAuthnController) ListCredentials(ctx *gin.Context) {
+	credentials, err := c.service.ListCredentials(ctx.GetUint("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, credentials)
+}
+
+func (c *WebAuthnController) DeleteCredential(ctx *gin.Context) {
+	id, ok := uintParam(ctx, "id")
+	if !ok {
+		return
+	}
+
+	if err := c.service.DeleteCredential(ctx.GetUint("user_id"), id); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Credential deleted"})
+}
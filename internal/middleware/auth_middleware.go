@@ -2,25 +2,39 @@ package middleware
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"skyphin-api/internal/config"
 	"skyphin-api/internal/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 type AuthMiddleware struct {
-	authService *services.AuthService
-	cfg         config.Config
+	authService   *services.AuthService
+	apiKeyService *services.APIKeyService
+	cfg           config.Config
 }
 
-func NewAuthMiddleware(authService *services.AuthService, cfg config.Config) *AuthMiddleware {
-	return &AuthMiddleware{authService: authService, cfg: cfg}
+func NewAuthMiddleware(authService *services.AuthService, apiKeyService *services.APIKeyService, cfg config.Config) *AuthMiddleware {
+	return &AuthMiddleware{authService: authService, apiKeyService: apiKeyService, cfg: cfg}
 }
 
+// Authenticate accepts only a user's JWT access token. API keys are rejected,
+// so that a leaked key cannot manage the account it belongs to.
 func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
+	return m.authenticate(false)
+}
+
+// AuthenticateScoped also accepts API keys. Every route behind it must
+// declare the permission it needs with RequirePermission, which a key only
+// has if it was created with that scope.
+func (m *AuthMiddleware) AuthenticateScoped() gin.HandlerFunc {
+	return m.authenticate(true)
+}
+
+func (m *AuthMiddleware) authenticate(allowAPIKeys bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
@@ -34,28 +48,161 @@ func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
+		if strings.HasPrefix(tokenString, services.APIKeyPrefix) {
+			if !allowAPIKeys {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used for this endpoint"})
+				return
 			}
-			return []byte(m.cfg.Auth.AccessTokenSecret), nil
-		})
+			m.authenticateAPIKey(ctx, tokenString)
+			return
+		}
 
+		claims, err := m.authService.ParseAccessToken(tokenString)
 		if err != nil {
 			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
 		}
 
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			userID, ok := claims["user_id"].(float64)
-			if !ok {
-				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
-				return
-			}
+		if _, ok := claims["client_id"]; ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token was issued to another application"})
+			return
+		}
+
+		userID, ok := claims["user_id"].(float64)
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
+			return
+		}
+
+		active, err := m.authService.IsAccessTokenActive(tokenString)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+			return
+		}
+		if !active {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+			return
+		}
+
+		ctx.Set("user_id", uint(userID))
+		ctx.Set("access_token", tokenString)
+		ctx.Set("roles", stringClaims(claims["roles"]))
+		ctx.Set("permissions", stringClaims(claims["permissions"]))
+		if sessionID, ok := claims["sid"].(float64); ok {
+			ctx.Set("session_id", uint(sessionID))
+		}
+		if orgID, ok := claims["org_id"].(float64); ok {
+			ctx.Set("org_id", uint(orgID))
+			ctx.Set("org_role", claims["org_role"])
+		}
+		ctx.Next()
+	}
+}
+
+func (m *AuthMiddleware) authenticateAPIKey(ctx *gin.Context, key string) {
+	identity, err := m.apiKeyService.Authenticate(key)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		return
+	}
+
+	ctx.Set("user_id", identity.Key.UserID)
+	ctx.Set("api_key_id", identity.Key.ID)
+	ctx.Set("roles", []string{})
+	ctx.Set("permissions", identity.Permissions)
+	if identity.Membership != nil {
+		ctx.Set("org_id", identity.Membership.OrganizationID)
+		ctx.Set("org_role", identity.Membership.Role)
+	}
+	ctx.Next()
+}
+
+// AuthenticateClientToken accepts only access tokens issued to OAuth clients
+// on behalf of a user, and only if they were granted scope. Errors follow
+// RFC 6750.
+func (m *AuthMiddleware) AuthenticateClientToken(scope string) gin.HandlerFunc {
+	return m.authenticateClient(scope, true)
+}
+
+// AuthenticateClient also accepts client_credentials tokens, which act for
+// the client alone. Routes behind it must not act for a user: user_id is
+// only set when the token has one.
+func (m *AuthMiddleware) AuthenticateClient(scope string) gin.HandlerFunc {
+	return m.authenticateClient(scope, false)
+}
+
+func (m *AuthMiddleware) authenticateClient(scope string, requireUser bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tokenString, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !ok || tokenString == "" {
+			ctx.Header("WWW-Authenticate", `Bearer`)
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_request"})
+			return
+		}
+
+		claims, err := m.authService.ParseAccessToken(tokenString)
+		if err != nil {
+			abortInvalidToken(ctx)
+			return
+		}
+
+		userID, hasUser := claims["user_id"].(float64)
+		clientID, hasClient := claims["client_id"].(string)
+		if !hasClient || (requireUser && !hasUser) {
+			abortInvalidToken(ctx)
+			return
+		}
+
+		active, err := m.authService.IsAccessTokenActive(tokenString)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+			return
+		}
+		if !active {
+			abortInvalidToken(ctx)
+			return
+		}
+
+		granted, _ := claims["scope"].(string)
+		if !slices.Contains(strings.Fields(granted), scope) {
+			ctx.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient_scope"})
+			return
+		}
+
+		if hasUser {
 			ctx.Set("user_id", uint(userID))
-			ctx.Next()
-		} else {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		}
+		ctx.Set("client_id", clientID)
+		ctx.Set("scope", granted)
+		ctx.Next()
+	}
+}
+
+func abortInvalidToken(ctx *gin.Context) {
+	ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+	ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+}
+
+// RequirePermission only lets through tokens carrying the given permission.
+// It must run after Authenticate.
+func (m *AuthMiddleware) RequirePermission(permission string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !slices.Contains(ctx.GetStringSlice("permissions"), permission) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + permission})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func stringClaims(claim any) []string {
+	values, _ := claim.([]any)
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if s, ok := value.(string); ok {
+			result = append(result, s)
 		}
 	}
+	return result
 }
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxRateLimitBodyBytes = 1 << 20
+
+// defaultRateLimitPolicies apply unless RATE_LIMIT_POLICIES overrides them by
+// name.
+var defaultRateLimitPolicies = []string{
+	"auth_ip=30/1m@ip",
+	"signup_ip=10/1h@ip",
+	"login_email=10/15m@field:email",
+	"reset_email=3/1h@field:email",
+	"magic_link_email=5/1h@field:email",
+	"mfa_token=10/15m@field:mfa_token",
+	"magic_link_binding=10/15m@cookie:magic_link_binding",
+	"refresh_token=10/1m@field:refresh_token",
+	"verify_token=10/15m@field:token",
+	"reset_token=10/15m@field:token",
+	"unlock_token=10/15m@field:token",
+	"api_user=600/1m@user",
+}
+
+type RateLimitMiddleware struct {
+	store ratelimit.Store
+	// fallback takes over, per instance, while store fails.
+	fallback ratelimit.Store
+	policies map[string]ratelimit.Policy
+}
+
+// NewRateLimitMiddleware returns a middleware that lets every request
+// through when store is nil.
+func NewRateLimitMiddleware(store ratelimit.Store, cfg config.RateLimitConfig) (*RateLimitMiddleware, error) {
+	m := &RateLimitMiddleware{store: store, fallback: ratelimit.NewMemoryStore(), policies: make(map[string]ratelimit.Policy)}
+
+	for _, spec := range slices.Concat(defaultRateLimitPolicies, cfg.Policies) {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		policy, err := ratelimit.ParsePolicy(spec)
+		if err != nil {
+			return nil, err
+		}
+		m.policies[policy.Name] = policy
+	}
+
+	return m, nil
+}
+
+// Limit enforces the named policy. Policies keyed by user must run after
+// Authenticate.
+func (m *RateLimitMiddleware) Limit(name string) (gin.HandlerFunc, error) {
+	policy, ok := m.policies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit policy %q", name)
+	}
+
+	return func(ctx *gin.Context) {
+		if m.store == nil {
+			ctx.Next()
+			return
+		}
+
+		key, ok := rateLimitKey(ctx, policy.Key)
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		result, err := m.store.Take(ctx.Request.Context(), policy.Name+":"+key, policy.Limit)
+		if err != nil {
+			// Limit per instance rather than not at all while the backend is
+			// unavailable.
+			log.Printf("Rate limit check for %s failed, limiting in process: %v", policy.Name, err)
+			result, _ = m.fallback.Take(ctx.Request.Context(), policy.Name+":"+key, policy.Limit)
+		}
+
+		ctx.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+		ctx.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("RateLimit-Reset", ceilSeconds(result.Reset))
+		ctx.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%s", policy.Limit.Requests, ceilSeconds(policy.Limit.Period)))
+
+		if !result.Allowed {
+			ctx.Header("Retry-After", ceilSeconds(result.RetryAfter))
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+			return
+		}
+		ctx.Next()
+	}, nil
+}
+
+// rateLimitKey returns the bucket key for the request, or false if the
+// request does not carry it.
+func rateLimitKey(ctx *gin.Context, key string) (string, bool) {
+	switch {
+	case key == "ip":
+		// Only forwarded by the proxies in TRUSTED_PROXIES.
+		return ctx.ClientIP(), true
+	case key == "user":
+		userID := ctx.GetUint("user_id")
+		return strconv.FormatUint(uint64(userID), 10), userID != 0
+	case strings.HasPrefix(key, "field:"):
+		value := strings.ToLower(strings.TrimSpace(bodyField(ctx, strings.TrimPrefix(key, "field:"))))
+		return digest(value), value != ""
+	case strings.HasPrefix(key, "cookie:"):
+		value, _ := ctx.Cookie(strings.TrimPrefix(key, "cookie:"))
+		return digest(value), value != ""
+	}
+	return "", false
+}
+
+// digest keeps emails and tokens out of the store's keys.
+func digest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// bodyField reads a string field from the JSON body and puts the body back
+// for the handler.
+func bodyField(ctx *gin.Context, name string) string {
+	if ctx.Request.Body == nil {
+		return ""
+	}
+
+	original := ctx.Request.Body
+	body, err := io.ReadAll(io.LimitReader(original, maxRateLimitBodyBytes))
+	ctx.Request.Body = readCloser{io.MultiReader(bytes.NewReader(body), original), original}
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+	value, _ := fields[name].(string)
+	return value
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failingStore is a backend that is down.
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+// newRateLimitedRouter serves POST / behind the policy spec, echoing the
+// request body.
+func newRateLimitedRouter(t *testing.T, store ratelimit.Store, spec string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	m, err := NewRateLimitMiddleware(store, config.RateLimitConfig{Policies: []string{spec}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ratelimit.ParsePolicy(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit, err := m.Limit(policy.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.POST("/", limit, func(ctx *gin.Context) {
+		body, _ := io.ReadAll(ctx.Request.Body)
+		ctx.String(http.StatusOK, string(body))
+	})
+	return router
+}
+
+func post(router *gin.Engine, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitSetsHeadersAndRejectsOnceEmpty(t *testing.T) {
+	router := newRateLimitedRouter(t, ratelimit.NewMemoryStore(), "test=2/1m@ip")
+
+	w := post(router, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	want := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "30",
+		"RateLimit-Policy":    "2;w=60",
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Errorf("Retry-After = %q on an allowed request", got)
+	}
+
+	post(router, "")
+	w = post(router, "")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+}
+
+func TestRateLimitFallsBackToMemoryWhenTheStoreFails(t *testing.T) {
+	router := newRateLimitedRouter(t, failingStore{}, "test=1/1m@ip")
+
+	if w := post(router, ""); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if w := post(router, ""); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 from the in-process fallback", w.Code)
+	}
+}
+
+func TestRateLimitOffLetsEverythingThrough(t *testing.T) {
+	router := newRateLimitedRouter(t, nil, "test=1/1m@ip")
+
+	for range 3 {
+		if w := post(router, ""); w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200", w.Code)
+		}
+	}
+}
+
+func TestRateLimitByFieldRestoresTheBody(t *testing.T) {
+	router := newRateLimitedRouter(t, ratelimit.NewMemoryStore(), "test=1/1m@field:email")
+	body := `{"email":"Jane@example.com","password":"secret"}`
+
+	w := post(router, body)
+	if w.Code != http.StatusOK || w.Body.String() != body {
+		t.Fatalf("handler read %q (status %d), want %q", w.Body.String(), w.Code, body)
+	}
+
+	// Keyed by the normalized email, whatever the IP.
+	if w := post(router, `{"email":" jane@EXAMPLE.com"}`); w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d for the same email, want 429", w.Code)
+	}
+	if w := post(router, `{"email":"john@example.com"}`); w.Code != http.StatusOK {
+		t.Errorf("status = %d for another email, want 200", w.Code)
+	}
+	// Requests without the field are not limited by it.
+	if w := post(router, `{}`); w.Code != http.StatusOK {
+		t.Errorf("status = %d without an email, want 200", w.Code)
+	}
+}
@@ -0,0 +1,114 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// schemaModels are the models whose tables the migrations create. Drift
+// compares them with the database.
+var schemaModels = []any{
+	&models.User{},
+	&models.PasswordHistory{},
+	&models.Session{},
+	&models.AccessToken{},
+	&models.RefreshToken{},
+	&models.VerificationToken{},
+	&models.ResetToken{},
+	&models.MagicLinkToken{},
+	&models.TokenReuseEvent{},
+	&models.TOTPSecret{},
+	&models.RecoveryCode{},
+	&models.MFAChallenge{},
+	&models.WebAuthnCredential{},
+	&models.WebAuthnSession{},
+	&models.OutboxMessage{},
+	&models.Permission{},
+	&models.Role{},
+	&models.UserRole{},
+	&models.Organization{},
+	&models.Membership{},
+	&models.Invitation{},
+	&models.LoginFailure{},
+	&models.UnlockToken{},
+	&models.AuditEvent{},
+	&models.APIKey{},
+	&models.OAuthClient{},
+	&models.OAuthAuthorizationCode{},
+	&models.OAuthConsent{},
+	&models.Identity{},
+	&models.FederatedLogin{},
+}
+
+// Drift lists the differences between the models and the database: missing
+// tables, columns and indexes, and tables or columns the models do not know.
+// An empty result means a migration is not missing for a model change.
+func (m *Migrator) Drift() ([]string, error) {
+	var drift []string
+	migrator := m.db.Migrator()
+	known := map[string]bool{AppliedMigration{}.TableName(): true}
+
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: m.db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+
+		table := stmt.Schema.Table
+		known[table] = true
+		for _, relation := range stmt.Schema.Relationships.Relations {
+			if relation.JoinTable != nil {
+				known[relation.JoinTable.Table] = true
+			}
+		}
+
+		if !migrator.HasTable(table) {
+			drift = append(drift, fmt.Sprintf("table %s is missing", table))
+			continue
+		}
+
+		columnTypes, err := migrator.ColumnTypes(model)
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, column := range columnTypes {
+			columns[column.Name()] = true
+			if stmt.Schema.LookUpField(column.Name()) == nil {
+				drift = append(drift, fmt.Sprintf("column %s.%s is not in the models", table, column.Name()))
+			}
+		}
+		for _, name := range stmt.Schema.DBNames {
+			if !columns[name] {
+				drift = append(drift, fmt.Sprintf("column %s.%s is missing", table, name))
+			}
+		}
+
+		indexes := stmt.Schema.ParseIndexes()
+		names := make([]string, 0, len(indexes))
+		for name := range indexes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !migrator.HasIndex(model, name) {
+				drift = append(drift, fmt.Sprintf("index %s on %s is missing", name, table))
+			}
+		}
+	}
+
+	tables, err := migrator.GetTables()
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if !known[table] {
+			drift = append(drift, fmt.Sprintf("table %s is not in the models", table))
+		}
+	}
+	return drift, nil
+}
@@ -0,0 +1,31 @@
+DROP TABLE IF EXISTS federated_logins;
+DROP TABLE IF EXISTS identities;
+DROP TABLE IF EXISTS o_auth_consents;
+DROP TABLE IF EXISTS o_auth_authorization_codes;
+DROP TABLE IF EXISTS o_auth_clients;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS unlock_tokens;
+DROP TABLE IF EXISTS login_failures;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS organizations;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS outbox_messages;
+DROP TABLE IF EXISTS web_authn_sessions;
+DROP TABLE IF EXISTS web_authn_credentials;
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS totp_secrets;
+DROP TABLE IF EXISTS token_reuse_events;
+DROP TABLE IF EXISTS magic_link_tokens;
+DROP TABLE IF EXISTS reset_tokens;
+DROP TABLE IF EXISTS verification_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS access_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS password_histories;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,406 @@
+-- The schema of every model at the switch to versioned migrations, as
+-- AutoMigrate creates it. It is not any one schema that AutoMigrate left
+-- behind: a database created that way, at whatever version, is brought up to
+-- this one by running AutoMigrate once more and then adopted at this version
+-- (see adoptLegacySchema).
+
+CREATE TABLE users (
+    id bigserial,
+    username text,
+    email text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    verified boolean,
+    mfa_enabled boolean,
+    active_organization_id bigint,
+    locked_until timestamptz,
+    encrypted_password text,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE password_histories (
+    id bigserial,
+    user_id bigint,
+    encrypted_password text,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_users_password_history FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX idx_password_histories_user_id ON password_histories (user_id);
+
+CREATE TABLE sessions (
+    id bigserial,
+    user_id bigint,
+    user_agent text,
+    ip text,
+    created_at timestamptz,
+    last_used_at timestamptz,
+    expires_at timestamptz,
+    revoked_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE access_tokens (
+    id bigserial,
+    user_id bigint,
+    session_id bigint,
+    client_id bigint,
+    token_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_access_tokens_session_id ON access_tokens (session_id);
+CREATE INDEX idx_access_tokens_user_id ON access_tokens (user_id);
+CREATE UNIQUE INDEX idx_access_tokens_token_hash ON access_tokens (token_hash);
+CREATE INDEX idx_access_tokens_client_id ON access_tokens (client_id);
+
+CREATE TABLE refresh_tokens (
+    id bigserial,
+    user_id bigint,
+    session_id bigint,
+    client_id bigint,
+    scope text,
+    family_id text,
+    parent_id bigint,
+    token_hash text,
+    used_at timestamptz,
+    revoked_at timestamptz,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens (user_id);
+CREATE UNIQUE INDEX idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX idx_refresh_tokens_client_id ON refresh_tokens (client_id);
+
+CREATE TABLE verification_tokens (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_verification_tokens_token_hash ON verification_tokens (token_hash);
+CREATE INDEX idx_verification_tokens_user_id ON verification_tokens (user_id);
+
+CREATE TABLE reset_tokens (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_reset_tokens_token_hash ON reset_tokens (token_hash);
+CREATE INDEX idx_reset_tokens_user_id ON reset_tokens (user_id);
+
+CREATE TABLE magic_link_tokens (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    code_hash text,
+    binding text,
+    attempts bigint,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_magic_link_tokens_binding ON magic_link_tokens (binding);
+CREATE UNIQUE INDEX idx_magic_link_tokens_token_hash ON magic_link_tokens (token_hash);
+CREATE INDEX idx_magic_link_tokens_user_id ON magic_link_tokens (user_id);
+
+CREATE TABLE token_reuse_events (
+    id bigserial,
+    user_id bigint,
+    family_id text,
+    token_id bigint,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_token_reuse_events_family_id ON token_reuse_events (family_id);
+CREATE INDEX idx_token_reuse_events_user_id ON token_reuse_events (user_id);
+
+CREATE TABLE totp_secrets (
+    id bigserial,
+    user_id bigint,
+    secret text,
+    last_used_step bigint,
+    confirmed_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_totp_secrets_user_id ON totp_secrets (user_id);
+
+CREATE TABLE recovery_codes (
+    id bigserial,
+    user_id bigint,
+    code_hash text,
+    used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_recovery_codes_code_hash ON recovery_codes (code_hash);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
+
+CREATE TABLE mfa_challenges (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    attempts bigint,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_mfa_challenges_token_hash ON mfa_challenges (token_hash);
+CREATE INDEX idx_mfa_challenges_user_id ON mfa_challenges (user_id);
+
+CREATE TABLE web_authn_credentials (
+    id bigserial,
+    user_id bigint,
+    name text,
+    credential_id bytea,
+    data bytea,
+    last_used_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_web_authn_credentials_credential_id ON web_authn_credentials (credential_id);
+CREATE INDEX idx_web_authn_credentials_user_id ON web_authn_credentials (user_id);
+
+CREATE TABLE web_authn_sessions (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    ceremony text,
+    data bytea,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_web_authn_sessions_token_hash ON web_authn_sessions (token_hash);
+CREATE INDEX idx_web_authn_sessions_user_id ON web_authn_sessions (user_id);
+
+CREATE TABLE outbox_messages (
+    id bigserial,
+    kind text,
+    payload bytea,
+    status text,
+    attempts bigint,
+    last_error text,
+    next_attempt_at timestamptz,
+    delivered_at timestamptz,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_outbox_messages_next_attempt_at ON outbox_messages (next_attempt_at);
+CREATE INDEX idx_outbox_messages_status ON outbox_messages (status);
+CREATE INDEX idx_outbox_messages_kind ON outbox_messages (kind);
+
+CREATE TABLE permissions (
+    id bigserial,
+    name text,
+    description text,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_permissions_name ON permissions (name);
+
+CREATE TABLE roles (
+    id bigserial,
+    name text,
+    description text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_roles_name ON roles (name);
+
+CREATE TABLE role_permissions (
+    role_id bigint,
+    permission_id bigint,
+    PRIMARY KEY (role_id,permission_id),
+    CONSTRAINT fk_role_permissions_role FOREIGN KEY (role_id) REFERENCES roles(id),
+    CONSTRAINT fk_role_permissions_permission FOREIGN KEY (permission_id) REFERENCES permissions(id)
+);
+
+CREATE TABLE user_roles (
+    user_id bigint,
+    role_id bigint,
+    created_at timestamptz,
+    PRIMARY KEY (user_id,role_id)
+);
+CREATE INDEX idx_user_roles_role_id ON user_roles (role_id);
+
+CREATE TABLE organizations (
+    id bigserial,
+    name text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE memberships (
+    id bigserial,
+    organization_id bigint,
+    user_id bigint,
+    role text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_memberships_organization FOREIGN KEY (organization_id) REFERENCES organizations(id),
+    CONSTRAINT fk_memberships_user FOREIGN KEY (user_id) REFERENCES users(id)
+);
+CREATE INDEX idx_memberships_user_id ON memberships (user_id);
+CREATE UNIQUE INDEX idx_membership_org_user ON memberships (organization_id,user_id);
+
+CREATE TABLE invitations (
+    id bigserial,
+    organization_id bigint,
+    email text,
+    role text,
+    token_hash text,
+    status text,
+    invited_by_id bigint,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_invitations_organization FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+CREATE UNIQUE INDEX idx_invitations_token_hash ON invitations (token_hash);
+CREATE INDEX idx_invitations_email ON invitations (email);
+CREATE INDEX idx_invitations_organization_id ON invitations (organization_id);
+
+CREATE TABLE login_failures (
+    id bigserial,
+    email text,
+    ip text,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_login_failures_ip ON login_failures (ip);
+CREATE INDEX idx_login_failures_email ON login_failures (email);
+CREATE INDEX idx_login_failures_created_at ON login_failures (created_at);
+
+CREATE TABLE unlock_tokens (
+    id bigserial,
+    user_id bigint,
+    token_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_unlock_tokens_token_hash ON unlock_tokens (token_hash);
+CREATE INDEX idx_unlock_tokens_user_id ON unlock_tokens (user_id);
+
+CREATE TABLE audit_events (
+    id bigserial,
+    type text,
+    user_id bigint,
+    actor_id bigint,
+    ip text,
+    user_agent text,
+    request_id text,
+    details jsonb,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_audit_events_type ON audit_events (type);
+CREATE INDEX idx_audit_events_created_at ON audit_events (created_at);
+CREATE INDEX idx_audit_events_request_id ON audit_events (request_id);
+CREATE INDEX idx_audit_events_ip ON audit_events (ip);
+CREATE INDEX idx_audit_events_user_id ON audit_events (user_id);
+
+CREATE TABLE api_keys (
+    id bigserial,
+    user_id bigint,
+    name text,
+    prefix text,
+    key_hash text,
+    scopes text,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_api_keys_key_hash ON api_keys (key_hash);
+CREATE INDEX idx_api_keys_user_id ON api_keys (user_id);
+
+CREATE TABLE o_auth_clients (
+    id bigserial,
+    client_id text,
+    secret_hash text,
+    name text,
+    redirect_uris text,
+    grant_types text,
+    scopes text,
+    confidential boolean,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_o_auth_clients_client_id ON o_auth_clients (client_id);
+
+CREATE TABLE o_auth_authorization_codes (
+    id bigserial,
+    code_hash text,
+    client_id bigint,
+    user_id bigint,
+    redirect_uri text,
+    scope text,
+    code_challenge text,
+    code_challenge_method text,
+    nonce text,
+    used_at timestamptz,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_o_auth_authorization_codes_user_id ON o_auth_authorization_codes (user_id);
+CREATE INDEX idx_o_auth_authorization_codes_client_id ON o_auth_authorization_codes (client_id);
+CREATE UNIQUE INDEX idx_o_auth_authorization_codes_code_hash ON o_auth_authorization_codes (code_hash);
+
+CREATE TABLE o_auth_consents (
+    id bigserial,
+    user_id bigint,
+    client_id bigint,
+    scope text,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_oauth_consent_user_client ON o_auth_consents (user_id,client_id);
+
+CREATE TABLE identities (
+    id bigserial,
+    user_id bigint,
+    provider text,
+    subject text,
+    email text,
+    last_login_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_identity_provider_subject ON identities (provider,subject);
+CREATE INDEX idx_identities_user_id ON identities (user_id);
+
+CREATE TABLE federated_logins (
+    id bigserial,
+    state text,
+    provider text,
+    nonce text,
+    code_verifier text,
+    user_id bigint,
+    login_code_hash text,
+    expires_at timestamptz,
+    created_at timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE UNIQUE INDEX idx_federated_logins_login_code_hash ON federated_logins (login_code_hash);
+CREATE UNIQUE INDEX idx_federated_logins_state ON federated_logins (state);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins_since;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins;
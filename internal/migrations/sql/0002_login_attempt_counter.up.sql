@@ -0,0 +1,7 @@
+-- Password attempts are counted on the user row, so that checking the limit
+-- and counting an attempt is a single conditional update. IF NOT EXISTS lets
+-- databases adopted through AutoMigrate, which already have the columns, run
+-- it too.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins bigint NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_logins_since timestamptz;
@@ -0,0 +1,8 @@
+-- Usernames are unique. Federated sign-ins used to take the provider's
+-- username as it was, so existing duplicates get the user ID appended, the
+-- oldest account keeping its name, before the index is created.
+
+UPDATE users SET username = username || '-' || id
+WHERE EXISTS (SELECT 1 FROM users older WHERE older.username = users.username AND older.id < users.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username ON users (username);
@@ -0,0 +1,2 @@
+-- The families given to legacy tokens are kept: they are valid families.
+SELECT 1;
@@ -0,0 +1,5 @@
+-- Refresh tokens issued before rotation existed have no family. Each gets
+-- its own, derived from its ID, so that concurrent refreshes with the same
+-- token agree on the family to revoke.
+
+UPDATE refresh_tokens SET family_id = 'legacy-' || id WHERE family_id IS NULL OR family_id = '';
@@ -0,0 +1,9 @@
+-- Emails are unique whatever their case, as they are looked up. Registration
+-- only checked for an existing account before creating one, so existing
+-- duplicates get the user ID appended, the oldest account keeping its email,
+-- before the index is created.
+
+UPDATE users SET email = email || '-' || id
+WHERE EXISTS (SELECT 1 FROM users older WHERE lower(older.email) = lower(users.email) AND older.id < users.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
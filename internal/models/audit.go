@@ -0,0 +1,37 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// AuditEvent is an append-only record of a security-relevant action.
+type AuditEvent struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	Type      string          `gorm:"index" json:"type"`
+	UserID    *uint           `gorm:"index" json:"user_id"`
+	ActorID   *uint           `json:"actor_id,omitempty"`
+	IP        string          `gorm:"index" json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	RequestID string          `gorm:"index" json:"request_id"`
+	Details   json.RawMessage `gorm:"type:jsonb" json:"details,omitempty"`
+	CreatedAt time.Time       `gorm:"index" json:"created_at"`
+}
+
+// ClientInfo identifies where a request came from.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+type AuditEventFilter struct {
+	Type      string     `form:"type"`
+	UserID    *uint      `form:"user_id"`
+	IP        string     `form:"ip"`
+	RequestID string     `form:"request_id"`
+	Since     *time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until     *time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit     int        `form:"limit,default=50" binding:"min=1,max=500"`
+	Offset    int        `form:"offset" binding:"min=0"`
+}
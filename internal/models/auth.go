@@ -2,10 +2,13 @@ package models
 
 import "time"
 
+// Token tables keep a keyed digest of each token in TokenHash. Token holds
+// the plain value only on rows about to be created and is never stored.
 type VerificationToken struct {
 	ID        uint   `gorm:"primaryKey"`
 	UserID    uint   `gorm:"index"`
-	Token     string `gorm:"token"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
@@ -13,27 +16,82 @@ type VerificationToken struct {
 type AccessToken struct {
 	ID        uint   `gorm:"primaryKey"`
 	UserID    uint   `gorm:"index"`
-	Token     string `gorm:"token"`
+	SessionID *uint  `gorm:"index"`
+	ClientID  *uint  `gorm:"index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
 
 type RefreshToken struct {
+	ID        uint  `gorm:"primaryKey"`
+	UserID    uint  `gorm:"index"`
+	SessionID *uint `gorm:"index"`
+	ClientID  *uint `gorm:"index"`
+	Scope     string
+	FamilyID  string `gorm:"index"`
+	ParentID  *uint
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// TokenReuseEvent records a refresh token that was presented again after it
+// had already been rotated, which is treated as a sign of token theft.
+type TokenReuseEvent struct {
 	ID        uint   `gorm:"primaryKey"`
 	UserID    uint   `gorm:"index"`
-	Token     string `gorm:"token"`
-	ExpiresAt time.Time
+	FamilyID  string `gorm:"index"`
+	TokenID   uint
 	CreatedAt time.Time
 }
 
 type ResetToken struct {
 	ID        uint   `gorm:"primaryKey"`
 	UserID    uint   `gorm:"index"`
-	Token     string `gorm:"token"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// MagicLinkToken is a pending passwordless login. The emailed link carries a
+// signed form of Token, the email also shows Code, and either one only works
+// in the browser holding the binding secret whose hash is Binding.
+type MagicLinkToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
+	Code      string `gorm:"-"`
+	CodeHash  string
+	Binding   string `gorm:"uniqueIndex"`
+	Attempts  int
 	ExpiresAt time.Time
 	CreatedAt time.Time
 }
 
+// JSONWebKey is the public part of a signing key as published in the JWKS.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
 type VerifyAccountRequest struct {
 	Token string `gorm:"token"`
 }
@@ -43,15 +101,30 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// MagicLinkVerifyRequest carries either the token from the emailed link or
+// the code shown in the email.
+type MagicLinkVerifyRequest struct {
+	Token string `json:"token" binding:"required_without=Code"`
+	Code  string `json:"code" binding:"required_without=Token,omitempty,len=6,numeric"`
+}
+
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type ResetPasswordRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
 type NewPasswordRequest struct {
 	Token    string `json:"token" binding:"required"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 }
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an external identity provider. A
+// user can have several.
+type Identity struct {
+	ID          uint       `gorm:"primaryKey" json:"id"`
+	UserID      uint       `gorm:"index" json:"-"`
+	Provider    string     `gorm:"uniqueIndex:idx_identity_provider_subject" json:"provider"`
+	Subject     string     `gorm:"uniqueIndex:idx_identity_provider_subject" json:"-"`
+	Email       string     `json:"email"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// FederatedLogin tracks a sign-in with an external provider from the
+// redirect to the provider until the web app exchanges LoginCode for tokens.
+type FederatedLogin struct {
+	ID            uint   `gorm:"primaryKey"`
+	State         string `gorm:"uniqueIndex"`
+	Provider      string
+	Nonce         string
+	CodeVerifier  string
+	UserID        *uint
+	LoginCode     *string `gorm:"-"`
+	LoginCodeHash *string `gorm:"uniqueIndex"`
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
+
+type FederatedLoginExchangeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// LoginFailure records a failed password login for throttling and lockout.
+type LoginFailure struct {
+	ID        uint      `gorm:"primaryKey"`
+	Email     string    `gorm:"index"`
+	IP        string    `gorm:"index"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+type UnlockToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type UnlockAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
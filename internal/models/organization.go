@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+)
+
+type Organization struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type Membership struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	OrganizationID uint         `gorm:"uniqueIndex:idx_membership_org_user" json:"organization_id"`
+	UserID         uint         `gorm:"uniqueIndex:idx_membership_org_user;index" json:"user_id"`
+	Role           string       `json:"role"`
+	Organization   Organization `json:"organization"`
+	User           User         `json:"user"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+type Invitation struct {
+	ID             uint         `gorm:"primaryKey" json:"id"`
+	OrganizationID uint         `gorm:"index" json:"organization_id"`
+	Email          string       `gorm:"index" json:"email"`
+	Role           string       `json:"role"`
+	Token          string       `gorm:"-" json:"-"`
+	TokenHash      string       `gorm:"uniqueIndex" json:"-"`
+	Status         string       `json:"status"`
+	InvitedByID    uint         `json:"invited_by_id"`
+	Organization   Organization `json:"organization"`
+	ExpiresAt      time.Time    `json:"expires_at"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+type InvitationResponseRequest struct {
+	Token string `json:"token" binding:"required"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead"
+)
+
+// OutboxMessage is an email or domain event written in the same transaction
+// as the change that caused it and delivered later by the dispatcher.
+type OutboxMessage struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Kind          string          `gorm:"index" json:"kind"`
+	Payload       json.RawMessage `json:"-"` // Cleared once delivered.
+	Status        string          `gorm:"index" json:"status"`
+	Attempts      int             `json:"attempts"`
+	LastError     string          `json:"last_error"`
+	NextAttemptAt time.Time       `gorm:"index" json:"next_attempt_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
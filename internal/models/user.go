@@ -3,18 +3,47 @@ package models
 import "time"
 
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Verified  bool      `json:"verified"`
+	ID uint `gorm:"primaryKey" json:"id"`
+	// Unique. The index is left to migration 0003, which renames existing
+	// duplicates first; adopting a legacy schema would fail on them.
+	Username string `json:"username"`
+	// Unique whatever its case, by an index on lower(email) in migration 0005.
+	Email      string    `json:"email"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Verified   bool      `json:"verified"`
+	MFAEnabled bool      `json:"mfa_enabled"`
+	// Organization carried in the user's access tokens.
+	ActiveOrganizationID *uint `json:"active_organization_id"`
+	// Set while the account is locked after repeated failed logins.
+	LockedUntil *time.Time `json:"locked_until"`
+	// Password attempts since FailedLoginsSince. An attempt is counted before
+	// the password is checked, and a successful login clears the count.
+	FailedLogins      int        `gorm:"not null;default:0" json:"-"`
+	FailedLoginsSince *time.Time `json:"-"`
 	// Don't expose following fields in JSON
-	EncryptedPassword string `json:"-"`
+	EncryptedPassword string            `json:"-"`
+	PasswordHistory   []PasswordHistory `json:"-"`
+}
+
+// PasswordHistory keeps hashes of a user's recent passwords, including the
+// current one, to prevent reuse.
+type PasswordHistory struct {
+	ID                uint `gorm:"primaryKey"`
+	UserID            uint `gorm:"index"`
+	EncryptedPassword string
+	CreatedAt         time.Time
+}
+
+// FieldError explains why a request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 type CreateUserRequest struct {
 	Username string `json:"username" binding:"required"`
 	Email    string `json:"email" binding:"required"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
 }
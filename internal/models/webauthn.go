@@ -0,0 +1,39 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type WebAuthnCredential struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"index" json:"-"`
+	Name         string     `json:"name"`
+	CredentialID []byte     `gorm:"uniqueIndex" json:"-"`
+	Data         []byte     `json:"-"` // JSON-encoded webauthn.Credential
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony
+// between its begin and finish steps.
+type WebAuthnSession struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"uniqueIndex"`
+	Ceremony  string
+	Data      []byte
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type WebAuthnLoginBeginRequest struct {
+	Email string `json:"email"`
+}
+
+type WebAuthnFinishRequest struct {
+	SessionToken string          `json:"session_token" binding:"required"`
+	Name         string          `json:"name"`
+	Credential   json.RawMessage `json:"credential" binding:"required"`
+}
@@ -0,0 +1,56 @@
+package repositories
+
+import (
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewAPIKeyRepository(db *gorm.DB, hasher *TokenHasher) *APIKeyRepository {
+	return &APIKeyRepository{db: db, hasher: hasher}
+}
+
+func (r *APIKeyRepository) Create(key *models.APIKey) error {
+	key.KeyHash = r.hasher.Hash(key.Key)
+	return r.db.Create(key).Error
+}
+
+// FindActiveByKey returns the key unless it was revoked or has expired.
+func (r *APIKeyRepository) FindActiveByKey(plain string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", r.hasher.Hash(plain), time.Now()).
+		First(&key).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) FindByUserID(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// Revoke reports false if the user has no such active key.
+func (r *APIKeyRepository) Revoke(userID uint, id uint) (bool, error) {
+	result := r.db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// TouchLastUsed records a use, writing at most once per interval per key.
+func (r *APIKeyRepository) TouchLastUsed(id uint, interval time.Duration) error {
+	now := time.Now()
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		Update("last_used_at", now).Error
+}
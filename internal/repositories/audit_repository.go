@@ -0,0 +1,69 @@
+package repositories
+
+import (
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// AuditRepository only ever inserts and reads audit events.
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *AuditRepository) WithTx(tx *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: tx}
+}
+
+func (r *AuditRepository) Create(event *models.AuditEvent) error {
+	return r.db.Create(event).Error
+}
+
+// Find returns matching events, newest first.
+func (r *AuditRepository) Find(filter *models.AuditEventFilter) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.db.Scopes(auditFilter(filter)).
+		Order("id DESC").Limit(filter.Limit).Offset(filter.Offset).
+		Find(&events).Error
+	return events, err
+}
+
+// FindAfter returns up to limit matching events with an ID above afterID,
+// oldest first, for exporting the log in batches.
+func (r *AuditRepository) FindAfter(filter *models.AuditEventFilter, afterID uint, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	err := r.db.Scopes(auditFilter(filter)).
+		Where("id > ?", afterID).
+		Order("id ASC").Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func auditFilter(filter *models.AuditEventFilter) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if filter.Type != "" {
+			db = db.Where("type = ?", filter.Type)
+		}
+		if filter.UserID != nil {
+			db = db.Where("user_id = ?", *filter.UserID)
+		}
+		if filter.IP != "" {
+			db = db.Where("ip = ?", filter.IP)
+		}
+		if filter.RequestID != "" {
+			db = db.Where("request_id = ?", filter.RequestID)
+		}
+		if filter.Since != nil {
+			db = db.Where("created_at >= ?", *filter.Since)
+		}
+		if filter.Until != nil {
+			db = db.Where("created_at < ?", *filter.Until)
+		}
+		return db
+	}
+}
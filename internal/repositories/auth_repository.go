@@ -1,38 +1,58 @@
 package repositories
 
 import (
+	"time"
+
 	"skyphin-api/internal/models"
 
 	"gorm.io/gorm"
 )
 
+// AuthRepository stores tokens under their keyed digests. Methods take and
+// set the plain token; it never reaches the database.
 type AuthRepository struct {
-	db *gorm.DB
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewAuthRepository(db *gorm.DB, hasher *TokenHasher) *AuthRepository {
+	return &AuthRepository{db: db, hasher: hasher}
 }
 
-func NewAuthRepository(db *gorm.DB) *AuthRepository {
-	return &AuthRepository{db: db}
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *AuthRepository) WithTx(tx *gorm.DB) *AuthRepository {
+	return &AuthRepository{db: tx, hasher: r.hasher}
 }
 
 func (r *AuthRepository) CreateVerificationToken(token *models.VerificationToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
 	return r.db.Create(token).Error
 }
 
 func (r *AuthRepository) CreateResetToken(token *models.ResetToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
+	return r.db.Create(token).Error
+}
+
+func (r *AuthRepository) CreateMagicLinkToken(token *models.MagicLinkToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
+	token.CodeHash = r.hasher.Hash(token.Code)
 	return r.db.Create(token).Error
 }
 
 func (r *AuthRepository) CreateAccessToken(token *models.AccessToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
 	return r.db.Create(token).Error
 }
 
 func (r *AuthRepository) CreateRefreshToken(token *models.RefreshToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
 	return r.db.Create(token).Error
 }
 
 func (r *AuthRepository) FindVerificationToken(token string) (*models.VerificationToken, error) {
 	var at models.VerificationToken
-	if err := r.db.Where("token = ?", token).First(&at).Error; err != nil {
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&at).Error; err != nil {
 		return nil, err
 	}
 	return &at, nil
@@ -40,15 +60,23 @@ func (r *AuthRepository) FindVerificationToken(token string) (*models.Verificati
 
 func (r *AuthRepository) FindResetToken(token string) (*models.ResetToken, error) {
 	var at models.ResetToken
-	if err := r.db.Where("token = ?", token).First(&at).Error; err != nil {
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&at).Error; err != nil {
 		return nil, err
 	}
 	return &at, nil
 }
 
+func (r *AuthRepository) FindMagicLinkTokenByBinding(binding string) (*models.MagicLinkToken, error) {
+	var mt models.MagicLinkToken
+	if err := r.db.Where("binding = ?", binding).First(&mt).Error; err != nil {
+		return nil, err
+	}
+	return &mt, nil
+}
+
 func (r *AuthRepository) FindAccessToken(token string) (*models.AccessToken, error) {
 	var at models.AccessToken
-	if err := r.db.Where("token = ?", token).First(&at).Error; err != nil {
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&at).Error; err != nil {
 		return nil, err
 	}
 	return &at, nil
@@ -56,24 +84,121 @@ func (r *AuthRepository) FindAccessToken(token string) (*models.AccessToken, err
 
 func (r *AuthRepository) FindRefreshToken(token string) (*models.RefreshToken, error) {
 	var rt models.RefreshToken
-	if err := r.db.Where("token = ?", token).First(&rt).Error; err != nil {
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&rt).Error; err != nil {
 		return nil, err
 	}
 	return &rt, nil
 }
 
 func (r *AuthRepository) DeleteVerificationToken(token string) error {
-	return r.db.Where("token = ?", token).Delete(&models.VerificationToken{}).Error
+	return r.db.Where("token_hash = ?", r.hasher.Hash(token)).Delete(&models.VerificationToken{}).Error
 }
 
 func (r *AuthRepository) DeleteResetToken(token string) error {
-	return r.db.Where("token = ?", token).Delete(&models.ResetToken{}).Error
+	return r.db.Where("token_hash = ?", r.hasher.Hash(token)).Delete(&models.ResetToken{}).Error
+}
+
+func (r *AuthRepository) DeleteVerificationTokensByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.VerificationToken{}).Error
+}
+
+// DeleteBefore deletes up to limit rows of model matching condition, whose
+// single placeholder is bound to before, e.g. "expires_at < ?". It returns
+// how many were deleted, so callers can repeat until fewer than limit are.
+func (r *AuthRepository) DeleteBefore(model any, condition string, before time.Time, limit int) (int64, error) {
+	expired := r.db.Model(model).Select("id").Where(condition, before).Limit(limit)
+	result := r.db.Where("id IN (?)", expired).Delete(model)
+	return result.RowsAffected, result.Error
+}
+
+// DeleteMagicLinkToken reports whether the token still existed, so that only
+// one of several concurrent logins with it succeeds.
+func (r *AuthRepository) DeleteMagicLinkToken(id uint) (bool, error) {
+	result := r.db.Delete(&models.MagicLinkToken{}, id)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *AuthRepository) DeleteMagicLinkTokensByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.MagicLinkToken{}).Error
+}
+
+// MagicLinkMatches reports whether the plain value is the one behind digest,
+// such as a magic link's TokenHash or CodeHash.
+func (r *AuthRepository) MagicLinkMatches(value string, digest string) bool {
+	return r.hasher.Matches(value, digest)
+}
+
+// UseMagicLinkAttempt counts an attempt to enter the code and reports whether
+// one was still left.
+func (r *AuthRepository) UseMagicLinkAttempt(id uint, maxAttempts int) (bool, error) {
+	result := r.db.Model(&models.MagicLinkToken{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
 }
 
 func (r *AuthRepository) DeleteAccessToken(token string) error {
-	return r.db.Where("token = ?", token).Delete(&models.AccessToken{}).Error
+	return r.db.Where("token_hash = ?", r.hasher.Hash(token)).Delete(&models.AccessToken{}).Error
+}
+
+// ConsumeRefreshToken marks the token as used. It reports false if the token
+// was already used or revoked, so concurrent refreshes cannot both succeed.
+func (r *AuthRepository) ConsumeRefreshToken(token *models.RefreshToken) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", token.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *AuthRepository) RevokeRefreshTokenFamily(familyID string) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *AuthRepository) CreateTokenReuseEvent(event *models.TokenReuseEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *AuthRepository) DeleteAccessTokensByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.AccessToken{}).Error
 }
 
-func (r *AuthRepository) DeleteRefreshToken(token string) error {
-	return r.db.Where("token = ?", token).Delete(&models.RefreshToken{}).Error
+func (r *AuthRepository) RevokeRefreshTokensByUserID(userID uint) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func (r *AuthRepository) DeleteAccessTokensBySessionID(sessionID uint) error {
+	return r.db.Where("session_id = ?", sessionID).Delete(&models.AccessToken{}).Error
+}
+
+func (r *AuthRepository) RevokeRefreshTokensBySessionID(sessionID uint) error {
+	return r.db.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// DeleteAccessTokensByClientID removes the tokens issued to an OAuth client,
+// only those for userID unless it is 0.
+func (r *AuthRepository) DeleteAccessTokensByClientID(clientID uint, userID uint) error {
+	query := r.db.Where("client_id = ?", clientID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	return query.Delete(&models.AccessToken{}).Error
+}
+
+// RevokeRefreshTokensByClientID revokes the tokens issued to an OAuth client,
+// only those for userID unless it is 0.
+func (r *AuthRepository) RevokeRefreshTokensByClientID(clientID uint, userID uint) error {
+	query := r.db.Model(&models.RefreshToken{}).Where("client_id = ? AND revoked_at IS NULL", clientID)
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	return query.Update("revoked_at", time.Now()).Error
 }
@@ -0,0 +1,86 @@
+package repositories
+
+import (
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type IdentityRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewIdentityRepository(db *gorm.DB, hasher *TokenHasher) *IdentityRepository {
+	return &IdentityRepository{db: db, hasher: hasher}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *IdentityRepository) WithTx(tx *gorm.DB) *IdentityRepository {
+	return &IdentityRepository{db: tx, hasher: r.hasher}
+}
+
+func (r *IdentityRepository) Create(identity *models.Identity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *IdentityRepository) FindByProviderSubject(provider string, subject string) (*models.Identity, error) {
+	var identity models.Identity
+	if err := r.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *IdentityRepository) FindByUserID(userID uint) ([]models.Identity, error) {
+	var identities []models.Identity
+	err := r.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+func (r *IdentityRepository) TouchLastLogin(id uint, email string) error {
+	return r.db.Model(&models.Identity{}).Where("id = ?", id).
+		Updates(map[string]any{"last_login_at": time.Now(), "email": email}).Error
+}
+
+func (r *IdentityRepository) Delete(userID uint, id uint) (bool, error) {
+	result := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Identity{})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *IdentityRepository) CreateLogin(login *models.FederatedLogin) error {
+	return r.db.Create(login).Error
+}
+
+func (r *IdentityRepository) FindLoginByState(state string) (*models.FederatedLogin, error) {
+	var login models.FederatedLogin
+	if err := r.db.Where("state = ?", state).First(&login).Error; err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func (r *IdentityRepository) FindLoginByCode(code string) (*models.FederatedLogin, error) {
+	var login models.FederatedLogin
+	if err := r.db.Where("login_code_hash = ?", r.hasher.Hash(code)).First(&login).Error; err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func (r *IdentityRepository) UpdateLogin(login *models.FederatedLogin) error {
+	if login.LoginCode != nil {
+		hash := r.hasher.Hash(*login.LoginCode)
+		login.LoginCodeHash = &hash
+	}
+	return r.db.Save(login).Error
+}
+
+// DeleteLogin reports false if the login was already deleted, so a login code
+// can only be redeemed once.
+func (r *IdentityRepository) DeleteLogin(id uint) (bool, error) {
+	result := r.db.Delete(&models.FederatedLogin{}, id)
+	return result.RowsAffected == 1, result.Error
+}
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// FailureStats summarises the failed logins for an email or IP in a window.
+type FailureStats struct {
+	Count int64
+	Last  *time.Time
+}
+
+type LockoutRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewLockoutRepository(db *gorm.DB, hasher *TokenHasher) *LockoutRepository {
+	return &LockoutRepository{db: db, hasher: hasher}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *LockoutRepository) WithTx(tx *gorm.DB) *LockoutRepository {
+	return &LockoutRepository{db: tx, hasher: r.hasher}
+}
+
+func (r *LockoutRepository) CreateFailure(failure *models.LoginFailure) error {
+	failure.Email = failureEmail(failure.Email)
+	return r.db.Create(failure).Error
+}
+
+func (r *LockoutRepository) FailureStatsByEmail(email string, since time.Time) (*FailureStats, error) {
+	return r.failureStats("email = ?", failureEmail(email), since)
+}
+
+func (r *LockoutRepository) FailureStatsByIP(ip string, since time.Time) (*FailureStats, error) {
+	return r.failureStats("ip = ?", ip, since)
+}
+
+func (r *LockoutRepository) DeleteFailuresByEmail(email string) error {
+	return r.db.Where("email = ?", failureEmail(email)).Delete(&models.LoginFailure{}).Error
+}
+
+// failureEmail is the form failures are stored and looked up under, so that
+// "Jane@Example.com " and "jane@example.com" share one counter like they
+// share one account.
+func failureEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (r *LockoutRepository) CreateUnlockToken(token *models.UnlockToken) error {
+	token.TokenHash = r.hasher.Hash(token.Token)
+	return r.db.Create(token).Error
+}
+
+func (r *LockoutRepository) FindUnlockToken(token string) (*models.UnlockToken, error) {
+	var ut models.UnlockToken
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&ut).Error; err != nil {
+		return nil, err
+	}
+	return &ut, nil
+}
+
+func (r *LockoutRepository) DeleteUnlockTokensByUserID(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.UnlockToken{}).Error
+}
+
+func (r *LockoutRepository) failureStats(condition string, value string, since time.Time) (*FailureStats, error) {
+	var stats FailureStats
+	err := r.db.Model(&models.LoginFailure{}).
+		Select("COUNT(*) AS count, MAX(created_at) AS last").
+		Where(condition, value).
+		Where("created_at > ?", since).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
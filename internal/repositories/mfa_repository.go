@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type MFARepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewMFARepository(db *gorm.DB, hasher *TokenHasher) *MFARepository {
+	return &MFARepository{db: db, hasher: hasher}
+}
+
+// SaveTOTPSecret replaces any existing secret of the user.
+func (r *MFARepository) SaveTOTPSecret(secret *models.TOTPSecret) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", secret.UserID).Delete(&models.TOTPSecret{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(secret).Error
+	})
+}
+
+func (r *MFARepository) FindTOTPSecret(userID uint) (*models.TOTPSecret, error) {
+	var secret models.TOTPSecret
+	if err := r.db.Where("user_id = ?", userID).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (r *MFARepository) DeleteTOTPSecret(userID uint) error {
+	return r.db.Where("user_id = ?", userID).Delete(&models.TOTPSecret{}).Error
+}
+
+// AdvanceTOTPStep records step as the last accepted time step. It reports
+// false if that step or a later one was already used, preventing replay.
+func (r *MFARepository) AdvanceTOTPStep(secretID uint, step int64) (bool, error) {
+	result := r.db.Model(&models.TOTPSecret{}).
+		Where("id = ? AND last_used_step < ?", secretID, step).
+		Update("last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ConfirmTOTPSecret marks the secret confirmed and stores the user's
+// recovery codes in one transaction.
+func (r *MFARepository) ConfirmTOTPSecret(secret *models.TOTPSecret, codes []models.RecoveryCode) error {
+	for i := range codes {
+		codes[i].CodeHash = r.hasher.Hash(codes[i].Code)
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(secret).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", secret.UserID).Update("mfa_enabled", true).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", secret.UserID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// DisableMFA removes the user's secret and recovery codes.
+func (r *MFARepository) DisableMFA(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.TOTPSecret{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", userID).Update("mfa_enabled", false).Error
+	})
+}
+
+// ConsumeRecoveryCode marks an unused code as used and reports whether one
+// matched.
+func (r *MFARepository) ConsumeRecoveryCode(userID uint, code string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, r.hasher.Hash(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *MFARepository) CreateChallenge(challenge *models.MFAChallenge) error {
+	challenge.TokenHash = r.hasher.Hash(challenge.Token)
+	return r.db.Create(challenge).Error
+}
+
+func (r *MFARepository) FindChallenge(token string) (*models.MFAChallenge, error) {
+	var challenge models.MFAChallenge
+	if err := r.db.Where("token_hash = ?", r.hasher.Hash(token)).First(&challenge).Error; err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// UseChallengeAttempt counts an attempt to complete the challenge and reports
+// whether one was still left.
+func (r *MFARepository) UseChallengeAttempt(id uint, maxAttempts int) (bool, error) {
+	result := r.db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		UpdateColumn("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected > 0, result.Error
+}
+
+// DeleteChallenge reports whether the challenge still existed, so that only
+// one of several concurrent completions succeeds.
+func (r *MFARepository) DeleteChallenge(id uint) (bool, error) {
+	result := r.db.Delete(&models.MFAChallenge{}, id)
+	return result.RowsAffected > 0, result.Error
+}
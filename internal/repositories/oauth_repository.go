@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type OAuthRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewOAuthRepository(db *gorm.DB, hasher *TokenHasher) *OAuthRepository {
+	return &OAuthRepository{db: db, hasher: hasher}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *OAuthRepository) WithTx(tx *gorm.DB) *OAuthRepository {
+	return &OAuthRepository{db: tx, hasher: r.hasher}
+}
+
+func (r *OAuthRepository) CreateClient(client *models.OAuthClient) error {
+	if client.Secret != "" {
+		client.SecretHash = r.hasher.Hash(client.Secret)
+	}
+	return r.db.Create(client).Error
+}
+
+// ClientSecretMatches reports whether secret is the client's.
+func (r *OAuthRepository) ClientSecretMatches(client *models.OAuthClient, secret string) bool {
+	return r.hasher.Matches(secret, client.SecretHash)
+}
+
+func (r *OAuthRepository) FindClients() ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	err := r.db.Order("id").Find(&clients).Error
+	return clients, err
+}
+
+func (r *OAuthRepository) FindClientByID(id uint) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := r.db.First(&client, id).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *OAuthRepository) FindClientByClientID(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := r.db.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// DeleteClient removes the client together with its codes and consents.
+func (r *OAuthRepository) DeleteClient(id uint) error {
+	if err := r.db.Where("client_id = ?", id).Delete(&models.OAuthAuthorizationCode{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("client_id = ?", id).Delete(&models.OAuthConsent{}).Error; err != nil {
+		return err
+	}
+	return r.db.Delete(&models.OAuthClient{}, id).Error
+}
+
+func (r *OAuthRepository) CreateCode(code *models.OAuthAuthorizationCode) error {
+	code.CodeHash = r.hasher.Hash(code.Code)
+	return r.db.Create(code).Error
+}
+
+func (r *OAuthRepository) FindCode(code string) (*models.OAuthAuthorizationCode, error) {
+	var ac models.OAuthAuthorizationCode
+	if err := r.db.Where("code_hash = ?", r.hasher.Hash(code)).First(&ac).Error; err != nil {
+		return nil, err
+	}
+	return &ac, nil
+}
+
+// ConsumeCode marks the code as used. It reports false if it already was.
+func (r *OAuthRepository) ConsumeCode(id uint) (bool, error) {
+	result := r.db.Model(&models.OAuthAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *OAuthRepository) FindConsent(userID uint, clientID uint) (*models.OAuthConsent, error) {
+	var consent models.OAuthConsent
+	if err := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&consent).Error; err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (r *OAuthRepository) SaveConsent(consent *models.OAuthConsent) error {
+	return r.db.Save(consent).Error
+}
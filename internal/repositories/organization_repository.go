@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrganizationRepository queries tenant data. Every query on memberships and
+// invitations goes through inOrganization so rows of one organization cannot
+// be read or changed through another organization's ID.
+type OrganizationRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewOrganizationRepository(db *gorm.DB, hasher *TokenHasher) *OrganizationRepository {
+	return &OrganizationRepository{db: db, hasher: hasher}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *OrganizationRepository) WithTx(tx *gorm.DB) *OrganizationRepository {
+	return &OrganizationRepository{db: tx, hasher: r.hasher}
+}
+
+func inOrganization(orgID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("organization_id = ?", orgID)
+	}
+}
+
+func (r *OrganizationRepository) Create(org *models.Organization) error {
+	return r.db.Create(org).Error
+}
+
+func (r *OrganizationRepository) FindByID(id uint) (*models.Organization, error) {
+	var org models.Organization
+	if err := r.db.First(&org, id).Error; err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (r *OrganizationRepository) CreateMembership(membership *models.Membership) error {
+	return r.db.Omit(clause.Associations).Create(membership).Error
+}
+
+func (r *OrganizationRepository) FindMembershipsByUserID(userID uint) ([]models.Membership, error) {
+	var memberships []models.Membership
+	if err := r.db.Preload("Organization").Where("user_id = ?", userID).Order("id").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *OrganizationRepository) FindMembership(orgID uint, userID uint) (*models.Membership, error) {
+	var membership models.Membership
+	if err := r.db.Scopes(inOrganization(orgID)).Where("user_id = ?", userID).First(&membership).Error; err != nil {
+		return nil, err
+	}
+	return &membership, nil
+}
+
+func (r *OrganizationRepository) FindMembers(orgID uint) ([]models.Membership, error) {
+	var memberships []models.Membership
+	if err := r.db.Scopes(inOrganization(orgID)).Preload("User").Order("id").Find(&memberships).Error; err != nil {
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *OrganizationRepository) CountMembersWithRole(orgID uint, role string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.Membership{}).Scopes(inOrganization(orgID)).Where("role = ?", role).Count(&count).Error
+	return count, err
+}
+
+func (r *OrganizationRepository) UpdateMemberRole(orgID uint, userID uint, role string) error {
+	return r.db.Model(&models.Membership{}).Scopes(inOrganization(orgID)).
+		Where("user_id = ?", userID).
+		Update("role", role).Error
+}
+
+// RemoveMember deletes the membership and clears the user's active
+// organization if it pointed at orgID.
+func (r *OrganizationRepository) RemoveMember(orgID uint, userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Scopes(inOrganization(orgID)).Where("user_id = ?", userID).Delete(&models.Membership{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ? AND active_organization_id = ?", userID, orgID).
+			Update("active_organization_id", nil).Error
+	})
+}
+
+func (r *OrganizationRepository) CreateInvitation(invitation *models.Invitation) error {
+	invitation.TokenHash = r.hasher.Hash(invitation.Token)
+	return r.db.Omit(clause.Associations).Create(invitation).Error
+}
+
+func (r *OrganizationRepository) FindInvitations(orgID uint) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	err := r.db.Scopes(inOrganization(orgID)).
+		Where("status = ?", models.InvitationPending).
+		Order("id DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (r *OrganizationRepository) FindPendingInvitationsByEmail(email string) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	err := r.db.Preload("Organization").
+		Where("email = ? AND status = ?", email, models.InvitationPending).
+		Order("id DESC").
+		Find(&invitations).Error
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (r *OrganizationRepository) FindInvitationByToken(token string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	if err := r.db.Preload("Organization").Where("token_hash = ?", r.hasher.Hash(token)).First(&invitation).Error; err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+func (r *OrganizationRepository) UpdateInvitationStatus(orgID uint, id uint, status string) error {
+	return r.db.Model(&models.Invitation{}).Scopes(inOrganization(orgID)).
+		Where("id = ?", id).
+		Update("status", status).Error
+}
+
+func (r *OrganizationRepository) DeleteInvitation(orgID uint, id uint) error {
+	result := r.db.Scopes(inOrganization(orgID)).Where("id = ?", id).Delete(&models.Invitation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
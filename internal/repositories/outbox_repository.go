@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"time"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type OutboxRepository struct {
+	db *gorm.DB
+}
+
+func NewOutboxRepository(db *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *OutboxRepository) WithTx(tx *gorm.DB) *OutboxRepository {
+	return &OutboxRepository{db: tx}
+}
+
+func (r *OutboxRepository) Create(messages ...*models.OutboxMessage) error {
+	for _, msg := range messages {
+		if err := r.db.Create(msg).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimDue leases up to limit pending messages that are due by pushing their
+// next attempt past lease. Rows locked by another dispatcher are skipped.
+func (r *OutboxRepository) ClaimDue(limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	now := time.Now()
+
+	var messages []models.OutboxMessage
+	err := r.db.Raw(`
+		UPDATE outbox_messages
+		SET next_attempt_at = ?, attempts = attempts + 1, updated_at = ?
+		WHERE id IN (
+			SELECT id FROM outbox_messages
+			WHERE status = ? AND next_attempt_at <= ?
+			ORDER BY id
+			LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`,
+		now.Add(lease), now, models.OutboxStatusPending, now, limit,
+	).Scan(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// MarkDelivered also drops the payload, which for emails carries the raw
+// token that was sent.
+func (r *OutboxRepository) MarkDelivered(id uint) error {
+	return r.db.Model(&models.OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"status":       models.OutboxStatusDelivered,
+		"delivered_at": time.Now(),
+		"last_error":   "",
+		"payload":      nil,
+	}).Error
+}
+
+func (r *OutboxRepository) MarkFailed(id uint, lastError string, nextAttemptAt time.Time) error {
+	return r.db.Model(&models.OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"last_error":      lastError,
+		"next_attempt_at": nextAttemptAt,
+	}).Error
+}
+
+func (r *OutboxRepository) MarkDead(id uint, lastError string) error {
+	return r.db.Model(&models.OutboxMessage{}).Where("id = ?", id).Updates(map[string]any{
+		"status":     models.OutboxStatusDead,
+		"last_error": lastError,
+	}).Error
+}
+
+func (r *OutboxRepository) FindByStatus(status string, limit int, offset int) ([]models.OutboxMessage, error) {
+	var messages []models.OutboxMessage
+	if err := r.db.Where("status = ?", status).Order("id DESC").Limit(limit).Offset(offset).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Requeue moves a dead message back to pending with a fresh attempt budget.
+func (r *OutboxRepository) Requeue(id uint) error {
+	result := r.db.Model(&models.OutboxMessage{}).
+		Where("id = ? AND status = ?", id, models.OutboxStatusDead).
+		Updates(map[string]any{
+			"status":          models.OutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package repositories
+
+import (
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RBACRepository struct {
+	db *gorm.DB
+}
+
+func NewRBACRepository(db *gorm.DB) *RBACRepository {
+	return &RBACRepository{db: db}
+}
+
+func (r *RBACRepository) FindRoles() ([]models.Role, error) {
+	var roles []models.Role
+	if err := r.db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *RBACRepository) FindRoleByID(id uint) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.Preload("Permissions").First(&role, id).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RBACRepository) FindRoleByName(name string) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// SaveRole creates or updates the role and replaces its permissions.
+func (r *RBACRepository) SaveRole(role *models.Role) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		permissions := role.Permissions
+		if err := tx.Omit("Permissions").Save(role).Error; err != nil {
+			return err
+		}
+		return tx.Model(role).Association("Permissions").Replace(permissions)
+	})
+}
+
+func (r *RBACRepository) DeleteRole(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&models.UserRole{}).Error; err != nil {
+			return err
+		}
+		role := &models.Role{ID: id}
+		if err := tx.Model(role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		result := tx.Delete(role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+func (r *RBACRepository) FindPermissions() ([]models.Permission, error) {
+	var permissions []models.Permission
+	if err := r.db.Order("name").Find(&permissions).Error; err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// EnsurePermissions returns the named permissions, creating missing ones.
+func (r *RBACRepository) EnsurePermissions(names []string) ([]models.Permission, error) {
+	if len(names) == 0 {
+		return []models.Permission{}, nil
+	}
+
+	permissions := make([]models.Permission, len(names))
+	for i, name := range names {
+		permissions[i] = models.Permission{Name: name}
+	}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&permissions).Error; err != nil {
+		return nil, err
+	}
+
+	var found []models.Permission
+	if err := r.db.Where("name IN ?", names).Order("name").Find(&found).Error; err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+func (r *RBACRepository) AssignRole(userID uint, roleID uint) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserRole{UserID: userID, RoleID: roleID}).Error
+}
+
+func (r *RBACRepository) RemoveRole(userID uint, roleID uint) error {
+	result := r.db.Where("user_id = ? AND role_id = ?", userID, roleID).Delete(&models.UserRole{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *RBACRepository) FindRolesByUserID(userID uint) ([]models.Role, error) {
+	var roles []models.Role
+	err := r.db.Preload("Permissions").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Find(&roles).Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
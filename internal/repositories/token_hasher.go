@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const tokenMigrationBatchSize = 500
+
+// TokenHasher derives the keyed SHA-256 digests that bearer tokens are
+// stored under, so that reading the database is not enough to use them.
+type TokenHasher struct {
+	key []byte
+}
+
+func NewTokenHasher(key string) (*TokenHasher, error) {
+	if key == "" {
+		return nil, errors.New("TOKEN_HASH_KEY is required")
+	}
+	return &TokenHasher{key: []byte(key)}, nil
+}
+
+func (h *TokenHasher) Hash(token string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches compares in constant time.
+func (h *TokenHasher) Matches(token string, digest string) bool {
+	return hmac.Equal([]byte(h.Hash(token)), []byte(digest))
+}
+
+// hashedColumn is a column that used to hold plain tokens and the column
+// that now holds their digests. Only the baseline token tables ever held
+// plain tokens; every table added since was created with its digest column.
+type hashedColumn struct {
+	model  any
+	plain  string
+	digest string
+}
+
+var hashedColumns = []hashedColumn{
+	{&models.VerificationToken{}, "token", "token_hash"},
+	{&models.ResetToken{}, "token", "token_hash"},
+	{&models.AccessToken{}, "token", "token_hash"},
+	{&models.RefreshToken{}, "token", "token_hash"},
+}
+
+// HashStoredTokens moves tokens that older versions stored in plain text to
+// their digest columns and drops the plain columns. It must run after the
+// digest columns exist, and does nothing once every plain column is gone.
+func HashStoredTokens(db *gorm.DB, hasher *TokenHasher) error {
+	for _, column := range hashedColumns {
+		if !db.Migrator().HasColumn(column.model, column.plain) {
+			continue
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error { return hashColumn(tx, hasher, column) }); err != nil {
+			return fmt.Errorf("hashing %T.%s: %w", column.model, column.plain, err)
+		}
+	}
+	return nil
+}
+
+func hashColumn(tx *gorm.DB, hasher *TokenHasher, column hashedColumn) error {
+	type row struct {
+		ID    uint
+		Plain string
+	}
+
+	for {
+		var batch []row
+		err := tx.Model(column.model).
+			Select("id, " + column.plain + " AS plain").
+			Where("(" + column.digest + " IS NULL OR " + column.digest + " = '') AND " + column.plain + " <> ''").
+			Limit(tokenMigrationBatchSize).
+			Scan(&batch).Error
+		if err != nil {
+			return err
+		}
+
+		for _, r := range batch {
+			if err := tx.Model(column.model).Where("id = ?", r.ID).Update(column.digest, hasher.Hash(r.Plain)).Error; err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < tokenMigrationBatchSize {
+			return tx.Migrator().DropColumn(column.model, column.plain)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package repositories
+
+import "gorm.io/gorm"
+
+// Transactor runs a function inside a database transaction. Repositories
+// join it through their WithTx methods.
+type Transactor struct {
+	db *gorm.DB
+}
+
+func NewTransactor(db *gorm.DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+func (t *Transactor) Transaction(fn func(tx *gorm.DB) error) error {
+	return t.db.Transaction(fn)
+}
+
+// WithLock runs fn while this instance holds the Postgres advisory lock key.
+// It reports false without running fn when another connection holds it.
+func (t *Transactor) WithLock(key int64, fn func() error) (bool, error) {
+	acquired := false
+	err := t.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Raw("SELECT pg_try_advisory_lock(?)", key).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", key)
+
+		return fn()
+	})
+	return acquired, err
+}
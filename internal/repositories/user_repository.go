@@ -1,9 +1,19 @@
 package repositories
 
 import (
+	"errors"
+	"time"
+
 	"skyphin-api/internal/models"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrUsernameTaken = errors.New("username already exists")
+	ErrEmailTaken    = errors.New("email already exists")
 )
 
 type UserRepository struct {
@@ -14,8 +24,26 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// WithTx returns a copy of the repository that runs its queries in tx.
+func (r *UserRepository) WithTx(tx *gorm.DB) *UserRepository {
+	return &UserRepository{db: tx}
+}
+
+// Create returns ErrUsernameTaken or ErrEmailTaken if another account took
+// the username or email since it was checked.
 func (r *UserRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+	err := r.db.Create(user).Error
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "idx_users_username":
+			return ErrUsernameTaken
+		case "idx_users_email_lower":
+			return ErrEmailTaken
+		}
+	}
+	return err
 }
 
 func (r *UserRepository) Update(user *models.User) error {
@@ -30,9 +58,11 @@ func (r *UserRepository) FindByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
+// FindByEmail ignores case: addresses are stored as they were typed, and
+// providers do not always use the same case.
 func (r *UserRepository) FindByEmail(email string) (*models.User, error) {
 	var user models.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.db.Where("lower(email) = lower(?)", email).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -45,3 +75,69 @@ func (r *UserRepository) FindByUsername(username string) (*models.User, error) {
 	}
 	return &user, nil
 }
+
+func (r *UserRepository) SetActiveOrganization(userID uint, orgID *uint) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("active_organization_id", orgID).Error
+}
+
+// ReserveLoginAttempt counts a password attempt for user, unless the account
+// is locked or already had maxAttempts since windowStart. It reports whether
+// the attempt may go ahead and updates user.FailedLogins.
+func (r *UserRepository) ReserveLoginAttempt(user *models.User, maxAttempts int, windowStart time.Time) (bool, error) {
+	now := time.Now()
+	expired := "failed_logins_since IS NULL OR failed_logins_since < ?"
+
+	result := r.db.Model(user).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}, {Name: "failed_logins_since"}}}).
+		Where("locked_until IS NULL OR locked_until <= ?", now).
+		Where("failed_logins < ? OR "+expired, maxAttempts, windowStart).
+		UpdateColumns(map[string]any{
+			"failed_logins":       gorm.Expr("CASE WHEN "+expired+" THEN 1 ELSE failed_logins + 1 END", windowStart),
+			"failed_logins_since": gorm.Expr("CASE WHEN "+expired+" THEN ? ELSE failed_logins_since END", windowStart, now),
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) ResetFailedLogins(userID uint) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).UpdateColumns(map[string]any{"failed_logins": 0, "failed_logins_since": nil}).Error
+}
+
+func (r *UserRepository) SetLockedUntil(userID uint, until *time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("locked_until", until).Error
+}
+
+func (r *UserRepository) UpdatePassword(userID uint, encryptedPassword string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", userID).Update("encrypted_password", encryptedPassword).Error
+}
+
+func (r *UserRepository) CreatePasswordHistory(entry *models.PasswordHistory) error {
+	return r.db.Create(entry).Error
+}
+
+// FindPasswordHistory returns the user's most recent password hashes.
+func (r *UserRepository) FindPasswordHistory(userID uint, limit int) ([]models.PasswordHistory, error) {
+	var entries []models.PasswordHistory
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// PrunePasswordHistory keeps only the user's keep most recent entries.
+func (r *UserRepository) PrunePasswordHistory(userID uint, keep int) error {
+	recent := r.db.Model(&models.PasswordHistory{}).Select("id").
+		Where("user_id = ?", userID).Order("created_at DESC, id DESC").Limit(keep)
+	return r.db.Where("user_id = ? AND id NOT IN (?)", userID, recent).Delete(&models.PasswordHistory{}).Error
+}
+
+// List returns users ordered by ID. A non-empty search matches part of the
+// username or email.
+func (r *UserRepository) List(search string, limit int, offset int) ([]models.User, error) {
+	query := r.db.Order("id").Limit(limit).Offset(offset)
+	if search != "" {
+		pattern := "%" + search + "%"
+		query = query.Where("username ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+
+	var users []models.User
+	err := query.Find(&users).Error
+	return users, err
+}
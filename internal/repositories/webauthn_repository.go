@@ -0,0 +1,63 @@
+package repositories
+
+import (
+	"skyphin-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+type WebAuthnRepository struct {
+	db     *gorm.DB
+	hasher *TokenHasher
+}
+
+func NewWebAuthnRepository(db *gorm.DB, hasher *TokenHasher) *WebAuthnRepository {
+	return &WebAuthnRepository{db: db, hasher: hasher}
+}
+
+func (r *WebAuthnRepository) CreateCredential(credential *models.WebAuthnCredential) error {
+	return r.db.Create(credential).Error
+}
+
+func (r *WebAuthnRepository) UpdateCredential(credential *models.WebAuthnCredential) error {
+	return r.db.Save(credential).Error
+}
+
+func (r *WebAuthnRepository) FindCredentialsByUserID(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+func (r *WebAuthnRepository) DeleteCredential(userID uint, id uint) error {
+	result := r.db.Where("user_id = ? AND id = ?", userID, id).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *WebAuthnRepository) CreateSession(session *models.WebAuthnSession) error {
+	session.TokenHash = r.hasher.Hash(session.Token)
+	return r.db.Create(session).Error
+}
+
+func (r *WebAuthnRepository) FindSession(token string, ceremony string) (*models.WebAuthnSession, error) {
+	var session models.WebAuthnSession
+	if err := r.db.Where("token_hash = ? AND ceremony = ?", r.hasher.Hash(token), ceremony).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteSession reports whether the session still existed, so that only one
+// of several concurrent finishes with it succeeds.
+func (r *WebAuthnRepository) DeleteSession(token string) (bool, error) {
+	result := r.db.Where("token_hash = ?", r.hasher.Hash(token)).Delete(&models.WebAuthnSession{})
+	return result.RowsAffected > 0, result.Error
+}
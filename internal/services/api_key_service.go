@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+)
+
+const (
+	// APIKeyPrefix starts every API key so that they are easy to recognize,
+	// both for the middleware and for secret scanners.
+	APIKeyPrefix = "skp_"
+
+	apiKeySecretBytes      = 32
+	apiKeyDisplayLength    = len(APIKeyPrefix) + 8
+	apiKeyLastUsedInterval = time.Minute
+)
+
+// APIKeyIdentity is what an API key authenticates as: its owner, limited to
+// the key's scopes.
+type APIKeyIdentity struct {
+	Key         *models.APIKey
+	Permissions []string
+	Membership  *models.Membership
+}
+
+type APIKeyService struct {
+	repo  *repositories.APIKeyRepository
+	rbac  *RBACService
+	orgs  *OrganizationService
+	audit *AuditService
+}
+
+func NewAPIKeyService(repo *repositories.APIKeyRepository, rbac *RBACService, orgs *OrganizationService, audit *AuditService) *APIKeyService {
+	return &APIKeyService{repo: repo, rbac: rbac, orgs: orgs, audit: audit}
+}
+
+// Create issues a key limited to scopes, which must be permissions the user
+// holds. The plain key is returned only here.
+func (s *APIKeyService) Create(userID uint, req *models.CreateAPIKeyRequest, client models.ClientInfo) (*models.APIKey, string, error) {
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", errors.New("expiry must be in the future")
+	}
+
+	_, permissions, err := s.rbac.ClaimsForUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(permissions, scope) {
+			return nil, "", fmt.Errorf("scope %s is not one of your permissions", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	slices.Sort(scopes)
+
+	secret, err := randomHex(apiKeySecretBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	plain := APIKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    plain[:apiKeyDisplayLength],
+		Key:       plain,
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.repo.Create(key); err != nil {
+		return nil, "", err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditAPIKeyCreated, UserID: userID, Client: client, Details: map[string]any{"api_key_id": key.ID, "scopes": scopes}})
+	return key, plain, nil
+}
+
+func (s *APIKeyService) List(userID uint) ([]models.APIKey, error) {
+	return s.repo.FindByUserID(userID)
+}
+
+func (s *APIKeyService) Revoke(userID uint, id uint, client models.ClientInfo) error {
+	revoked, err := s.repo.Revoke(userID, id)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return errors.New("API key not found")
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditAPIKeyRevoked, UserID: userID, Client: client, Details: map[string]uint{"api_key_id": id}})
+	return nil
+}
+
+// Authenticate looks up an active key. Its permissions are its scopes minus
+// any the owner has lost since the key was created.
+func (s *APIKeyService) Authenticate(plain string) (*APIKeyIdentity, error) {
+	key, err := s.repo.FindActiveByKey(plain)
+	if err != nil {
+		return nil, errors.New("invalid API key")
+	}
+
+	_, userPermissions, err := s.rbac.ClaimsForUser(key.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	for _, scope := range key.Scopes {
+		if slices.Contains(userPermissions, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+
+	membership, err := s.orgs.ActiveMembership(key.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.TouchLastUsed(key.ID, apiKeyLastUsedInterval); err != nil {
+		log.Printf("Failed to update API key %d: %v", key.ID, err)
+	}
+
+	return &APIKeyIdentity{Key: key, Permissions: permissions, Membership: membership}, nil
+}
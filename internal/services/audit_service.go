@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditLoginSucceeded         = "login.succeeded"
+	AuditLoginFailed            = "login.failed"
+	AuditTokenRefreshed         = "token.refreshed"
+	AuditTokenReuseDetected     = "token.reuse_detected"
+	AuditAccountVerified        = "account.verified"
+	AuditPasswordResetRequested = "password_reset.requested"
+	AuditPasswordResetCompleted = "password_reset.completed"
+	AuditPasswordResetForced    = "password_reset.forced"
+	AuditRoleCreated            = "role.created"
+	AuditRoleUpdated            = "role.updated"
+	AuditRoleDeleted            = "role.deleted"
+	AuditRoleAssigned           = "role.assigned"
+	AuditRoleRemoved            = "role.removed"
+	AuditAccountLocked          = "account.locked"
+	AuditAccountUnlocked        = "account.unlocked"
+	AuditSessionRevoked         = "session.revoked"
+	AuditTokensRevoked          = "tokens.revoked"
+	AuditAPIKeyCreated          = "api_key.created"
+	AuditAPIKeyRevoked          = "api_key.revoked"
+	AuditOAuthClientCreated     = "oauth_client.created"
+	AuditOAuthClientDeleted     = "oauth_client.deleted"
+	AuditOAuthConsentGranted    = "oauth.consent_granted"
+	AuditIdentityLinked         = "identity.linked"
+	AuditIdentityUnlinked       = "identity.unlinked"
+	AuditMagicLinkRequested     = "magic_link.requested"
+
+	auditExportBatchSize = 500
+)
+
+// AuditEntry describes an audit event before it is stored. UserID is the
+// account the event is about and ActorID the user who caused it, when that
+// is someone else, such as an admin.
+type AuditEntry struct {
+	Type    string
+	UserID  uint
+	ActorID uint
+	Client  models.ClientInfo
+	Details any
+}
+
+type AuditService struct {
+	repo *repositories.AuditRepository
+}
+
+func NewAuditService(repo *repositories.AuditRepository) *AuditService {
+	return &AuditService{repo: repo}
+}
+
+// Record stores the entry. A failure is logged rather than returned so that
+// an audit problem never turns a successful action into an error.
+func (s *AuditService) Record(entry AuditEntry) {
+	if err := s.create(s.repo, entry); err != nil {
+		log.Printf("Failed to record audit event %s: %v", entry.Type, err)
+	}
+}
+
+// RecordTx stores the entry as part of tx, so it is only kept if the action
+// it describes is committed.
+func (s *AuditService) RecordTx(tx *gorm.DB, entry AuditEntry) error {
+	return s.create(s.repo.WithTx(tx), entry)
+}
+
+func (s *AuditService) List(filter *models.AuditEventFilter) ([]models.AuditEvent, error) {
+	return s.repo.Find(filter)
+}
+
+// Export writes every matching event as a line of JSON, oldest first.
+// Limit and offset in the filter are ignored.
+func (s *AuditService) Export(filter *models.AuditEventFilter, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+
+	var afterID uint
+	for {
+		events, err := s.repo.FindAfter(filter, afterID, auditExportBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range events {
+			if err := encoder.Encode(&events[i]); err != nil {
+				return err
+			}
+		}
+
+		if len(events) < auditExportBatchSize {
+			return nil
+		}
+		afterID = events[len(events)-1].ID
+	}
+}
+
+func (s *AuditService) create(repo *repositories.AuditRepository, entry AuditEntry) error {
+	event := &models.AuditEvent{
+		Type:      entry.Type,
+		UserID:    optionalID(entry.UserID),
+		ActorID:   optionalID(entry.ActorID),
+		IP:        entry.Client.IP,
+		UserAgent: entry.Client.UserAgent,
+		RequestID: entry.Client.RequestID,
+	}
+
+	if entry.Details != nil {
+		details, err := json.Marshal(entry.Details)
+		if err != nil {
+			return err
+		}
+		event.Details = details
+	}
+
+	return repo.Create(event)
+}
+
+func optionalID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
+
+func valueOf(id *uint) uint {
+	if id == nil {
+		return 0
+	}
+	return *id
+}
@@ -4,45 +4,89 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"log"
 	"skyphin-api/internal/config"
 	"skyphin-api/internal/models"
 	"skyphin-api/internal/repositories"
+	"skyphin-api/pkg/password"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type AuthService struct {
-	userRepo *repositories.UserRepository
-	authRepo *repositories.AuthRepository
-	cfg      config.Config
+	userRepo   *repositories.UserRepository
+	authRepo   *repositories.AuthRepository
+	sessions   *repositories.SessionRepository
+	outboxRepo *repositories.OutboxRepository
+	tx         *repositories.Transactor
+	rbac       *RBACService
+	orgs       *OrganizationService
+	lockout    *LockoutService
+	passwords  *PasswordService
+	audit      *AuditService
+	keys       *KeyManager
+	cfg        config.Config
+	tokenCache *tokenCache
 }
 
-func NewAuthService(userRepo *repositories.UserRepository, authRepo *repositories.AuthRepository, cfg config.Config) *AuthService {
-	return &AuthService{userRepo: userRepo, authRepo: authRepo, cfg: cfg}
-}
-
-func (s *AuthService) GenerateVerificationToken(userID uint) (string, error) {
-	token, err := generateRandomToken(32)
-	if err != nil {
-		return "", err
-	}
-
-	verificationToken := &models.VerificationToken{
-		UserID:    userID,
-		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour), // Token expires in 1 hour
-	}
-
-	if err := s.authRepo.CreateVerificationToken(verificationToken); err != nil {
-		return "", err
+func NewAuthService(userRepo *repositories.UserRepository, authRepo *repositories.AuthRepository, sessions *repositories.SessionRepository, outboxRepo *repositories.OutboxRepository, tx *repositories.Transactor, rbac *RBACService, orgs *OrganizationService, lockout *LockoutService, passwords *PasswordService, audit *AuditService, keys *KeyManager, cfg config.Config) *AuthService {
+	return &AuthService{
+		userRepo:   userRepo,
+		authRepo:   authRepo,
+		sessions:   sessions,
+		outboxRepo: outboxRepo,
+		tx:         tx,
+		rbac:       rbac,
+		orgs:       orgs,
+		lockout:    lockout,
+		passwords:  passwords,
+		audit:      audit,
+		keys:       keys,
+		cfg:        cfg,
+		tokenCache: newTokenCache(time.Second * time.Duration(cfg.Auth.TokenCacheTTLSeconds)),
 	}
+}
 
-	return token, nil
+// Register stores a new user together with a verification token and queues
+// the verification email in the same transaction.
+func (s *AuthService) Register(user *models.User) error {
+	return s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).Create(user); err != nil {
+			return err
+		}
+
+		token, err := generateRandomToken(32)
+		if err != nil {
+			return err
+		}
+
+		verificationToken := &models.VerificationToken{
+			UserID:    user.ID,
+			Token:     token,
+			ExpiresAt: time.Now().Add(time.Hour), // Token expires in 1 hour
+		}
+
+		if err := s.authRepo.WithTx(tx).CreateVerificationToken(verificationToken); err != nil {
+			return err
+		}
+
+		email, err := newOutboxMessage(OutboxVerificationEmail, emailPayload{To: user.Email, Token: token})
+		if err != nil {
+			return err
+		}
+		event, err := newOutboxMessage(OutboxUserRegistered, userEventPayload{UserID: user.ID, Email: user.Email})
+		if err != nil {
+			return err
+		}
+
+		return s.outboxRepo.WithTx(tx).Create(email, event)
+	})
 }
 
-func (s *AuthService) VerifyAccount(token string) error {
+func (s *AuthService) VerifyAccount(token string, client models.ClientInfo) error {
 	verificationToken, err := s.authRepo.FindVerificationToken(token)
 	if err != nil || verificationToken.ExpiresAt.Before(time.Now()) {
 		return errors.New("invalid or expired verification code")
@@ -53,42 +97,148 @@ func (s *AuthService) VerifyAccount(token string) error {
 		return errors.New("user not found")
 	}
 
-	user.Verified = true
+	return s.markVerified(user, AuditEntry{Type: AuditAccountVerified, UserID: user.ID, Client: client})
+}
 
-	if err := s.userRepo.Update(user); err != nil {
-		return err
+// MarkVerified verifies a user's account without a verification code, for
+// administrators.
+func (s *AuthService) MarkVerified(userID uint, client models.ClientInfo) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
 	}
 
-	if err := s.authRepo.DeleteVerificationToken(verificationToken.Token); err != nil {
+	return s.markVerified(user, AuditEntry{Type: AuditAccountVerified, UserID: user.ID, Client: client, Details: map[string]string{"method": "admin"}})
+}
+
+// markVerified saves the user as verified, discards their outstanding
+// verification codes and records the event.
+func (s *AuthService) markVerified(user *models.User, entry AuditEntry) error {
+	user.Verified = true
+
+	event, err := newOutboxMessage(OutboxUserVerified, userEventPayload{UserID: user.ID, Email: user.Email})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).Update(user); err != nil {
+			return err
+		}
+
+		if err := s.authRepo.WithTx(tx).DeleteVerificationTokensByUserID(user.ID); err != nil {
+			return err
+		}
+
+		if err := s.audit.RecordTx(tx, entry); err != nil {
+			return err
+		}
+
+		return s.outboxRepo.WithTx(tx).Create(event)
+	})
 }
 
-func (s *AuthService) Login(req *models.LoginRequest) (*models.User, error) {
+// Login checks the password. Failures are recorded against the email and the
+// client IP and may throttle the client or lock the account. A successful
+// check is not a completed login until CompleteLogin is called, which may
+// happen after a second factor.
+func (s *AuthService) Login(req *models.LoginRequest, client models.ClientInfo) (*models.User, error) {
+	if err := s.lockout.CheckThrottle(req.Email, client.IP); err != nil {
+		s.recordLoginFailure(0, req.Email, "throttled", client)
+		return nil, err
+	}
+
 	user, err := s.userRepo.FindByEmail(req.Email)
 	if err != nil {
+		s.recordLoginFailure(0, req.Email, "unknown_email", client)
+		if err := s.lockout.RecordFailure(req.Email, client, nil); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("invalid email or password")
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.EncryptedPassword), []byte(req.Password))
-	if err != nil {
+	if err := s.lockout.ReserveAttempt(user); err != nil {
+		if errors.Is(err, ErrAccountLocked) {
+			s.recordLoginFailure(user.ID, req.Email, "locked", client)
+		}
+		return nil, err
+	}
+
+	rehash, err := s.passwords.Verify(req.Password, user.EncryptedPassword)
+	if errors.Is(err, password.ErrMismatch) {
+		s.recordLoginFailure(user.ID, req.Email, "wrong_password", client)
+		if err := s.lockout.RecordFailure(req.Email, client, user); err != nil {
+			return nil, err
+		}
 		return nil, errors.New("invalid email or password")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.lockout.RecordSuccess(req.Email, user.ID); err != nil {
+		return nil, err
+	}
+
+	if rehash {
+		if err := s.upgradePasswordHash(user, req.Password); err != nil {
+			log.Printf("Failed to upgrade password hash for user %d: %v", user.ID, err)
+		}
+	}
 
 	return user, nil
 }
 
-func (s *AuthService) GenerateResetToken(email string) (string, error) {
+// CompleteLogin starts a session for a user who passed every login step,
+// issues its tokens and records the login. method names the final step, e.g.
+// "password", "mfa" or "webauthn".
+func (s *AuthService) CompleteLogin(user *models.User, method string, client models.ClientInfo) (string, string, error) {
+	session, err := s.startSession(user.ID, client)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessToken, refreshToken, err := s.GenerateTokens(user, session.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditLoginSucceeded, UserID: user.ID, Client: client, Details: map[string]any{"method": method, "session_id": session.ID}})
+	return accessToken, refreshToken, nil
+}
+
+func (s *AuthService) recordLoginFailure(userID uint, email string, reason string, client models.ClientInfo) {
+	s.audit.Record(AuditEntry{
+		Type:    AuditLoginFailed,
+		UserID:  userID,
+		Client:  client,
+		Details: map[string]string{"email": email, "reason": reason},
+	})
+}
+
+// upgradePasswordHash rehashes a password that was hashed with an outdated
+// algorithm or parameters.
+func (s *AuthService) upgradePasswordHash(user *models.User, plain string) error {
+	hashed, err := s.passwords.Hash(plain)
+	if err != nil {
+		return err
+	}
+
+	user.EncryptedPassword = hashed
+	return s.userRepo.UpdatePassword(user.ID, hashed)
+}
+
+// RequestPasswordReset stores a reset token and queues the reset email in the
+// same transaction.
+func (s *AuthService) RequestPasswordReset(email string, client models.ClientInfo) error {
 	user, err := s.userRepo.FindByEmail(email)
 	if err != nil {
-		return "", errors.New("user not found")
+		return errors.New("user not found")
 	}
 
 	token, err := generateRandomToken(32)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	resetToken := &models.ResetToken{
@@ -97,14 +247,23 @@ func (s *AuthService) GenerateResetToken(email string) (string, error) {
 		ExpiresAt: time.Now().Add(time.Hour), // Token expires in 1 hour
 	}
 
-	if err := s.authRepo.CreateResetToken(resetToken); err != nil {
-		return "", err
+	msg, err := newOutboxMessage(OutboxPasswordResetEmail, emailPayload{To: user.Email, Token: token})
+	if err != nil {
+		return err
 	}
 
-	return token, nil
+	return s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.authRepo.WithTx(tx).CreateResetToken(resetToken); err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditPasswordResetRequested, UserID: user.ID, Client: client}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(msg)
+	})
 }
 
-func (s *AuthService) ResetPassword(req *models.NewPasswordRequest) error {
+func (s *AuthService) ResetPassword(req *models.NewPasswordRequest, client models.ClientInfo) error {
 	if req.Token == "" {
 		return errors.New("empty token")
 	}
@@ -119,31 +278,50 @@ func (s *AuthService) ResetPassword(req *models.NewPasswordRequest) error {
 		return errors.New("user not found")
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
+	if err := s.passwords.Validate(user, req.Password); err != nil {
 		return err
 	}
 
-	user.EncryptedPassword = string(hashedPassword)
-
-	if err := s.userRepo.Update(user); err != nil {
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
 		return err
 	}
 
-	if err := s.authRepo.DeleteResetToken(resetToken.Token); err != nil { // Delete the token after use.
+	user.EncryptedPassword = hashedPassword
+
+	event, err := newOutboxMessage(OutboxPasswordReset, userEventPayload{UserID: user.ID, Email: user.Email})
+	if err != nil {
 		return err
 	}
 
-	return nil
+	return s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).Update(user); err != nil {
+			return err
+		}
+
+		if err := s.passwords.Remember(tx, user.ID, hashedPassword); err != nil {
+			return err
+		}
+
+		if err := s.authRepo.WithTx(tx).DeleteResetToken(req.Token); err != nil { // Delete the token after use.
+			return err
+		}
+
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditPasswordResetCompleted, UserID: user.ID, Client: client}); err != nil {
+			return err
+		}
+
+		return s.outboxRepo.WithTx(tx).Create(event)
+	})
 }
 
-func (s *AuthService) GenerateTokens(user *models.User) (string, string, error) {
-	accessToken, err := s.generateAccessToken(user.ID)
+func (s *AuthService) GenerateTokens(user *models.User, sessionID uint) (string, string, error) {
+	accessToken, err := s.generateAccessToken(user.ID, sessionID)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err := s.generateRefreshToken(user.ID)
+	refreshToken, err := s.generateRefreshToken(&models.RefreshToken{UserID: user.ID, SessionID: optionalID(sessionID)})
 	if err != nil {
 		return "", "", err
 	}
@@ -151,22 +329,51 @@ func (s *AuthService) GenerateTokens(user *models.User) (string, string, error)
 	return accessToken, refreshToken, nil
 }
 
-func (s *AuthService) generateAccessToken(userID uint) (string, error) {
+// IssueAccessToken returns a fresh access token for the session, e.g. after
+// the user switches their active organization.
+func (s *AuthService) IssueAccessToken(userID uint, sessionID uint) (string, error) {
+	return s.generateAccessToken(userID, sessionID)
+}
+
+// generateAccessToken embeds the user's roles and permissions as they are
+// now; changes take effect with the next token.
+func (s *AuthService) generateAccessToken(userID uint, sessionID uint) (string, error) {
+	roles, permissions, err := s.rbac.ClaimsForUser(userID)
+	if err != nil {
+		return "", err
+	}
+
 	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Minute * time.Duration(s.cfg.Auth.AccessTokenExpiryMinutes)).Unix(),
+		"user_id":     userID,
+		"roles":       roles,
+		"permissions": permissions,
+		"iat":         time.Now().Unix(),
+		"exp":         time.Now().Add(s.accessTokenLifetime()).Unix(),
+	}
+
+	if sessionID != 0 {
+		claims["sid"] = sessionID
+	}
+
+	membership, err := s.orgs.ActiveMembership(userID)
+	if err != nil {
+		return "", err
+	}
+	if membership != nil {
+		claims["org_id"] = membership.OrganizationID
+		claims["org_role"] = membership.Role
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte(s.cfg.Auth.AccessTokenSecret))
+	signedToken, err := s.keys.Sign(claims)
 	if err != nil {
 		return "", err
 	}
 
 	accessToken := &models.AccessToken{
 		UserID:    userID,
+		SessionID: optionalID(sessionID),
 		Token:     signedToken,
-		ExpiresAt: time.Now().Add(time.Minute * time.Duration(s.cfg.Auth.AccessTokenExpiryMinutes)),
+		ExpiresAt: time.Now().Add(s.accessTokenLifetime()),
 	}
 
 	if err := s.authRepo.CreateAccessToken(accessToken); err != nil {
@@ -176,42 +383,577 @@ func (s *AuthService) generateAccessToken(userID uint) (string, error) {
 	return signedToken, nil
 }
 
-func (s *AuthService) generateRefreshToken(userID uint) (string, error) {
+// generateRefreshToken stores token with a new random value, starting a new
+// family unless token already names one.
+func (s *AuthService) generateRefreshToken(token *models.RefreshToken) (string, error) {
 	refreshTokenStr, err := generateRandomToken(64)
 	if err != nil {
 		return "", err
 	}
 
-	refreshToken := &models.RefreshToken{
-		UserID:    userID,
-		Token:     refreshTokenStr,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * time.Duration(s.cfg.Auth.RefreshTokenExpiryDays)),
+	if token.FamilyID == "" {
+		token.FamilyID, err = generateRandomToken(16)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	if err := s.authRepo.CreateRefreshToken(refreshToken); err != nil {
+	token.Token = refreshTokenStr
+	token.ExpiresAt = time.Now().Add(s.refreshTokenLifetime())
+
+	if err := s.authRepo.CreateRefreshToken(token); err != nil {
 		return "", err
 	}
 
 	return refreshTokenStr, nil
 }
 
-func (s *AuthService) RefreshAccessToken(refreshTokenStr string) (string, error) {
-	refreshToken, err := s.authRepo.FindRefreshToken(refreshTokenStr)
-	if err != nil || refreshToken.ExpiresAt.Before(time.Now()) {
-		return "", errors.New("invalid or expired refresh token")
+// RefreshAccessToken consumes the presented refresh token and returns a new
+// access token together with its replacement in the same family. Presenting a
+// token that was already consumed revokes the whole family.
+func (s *AuthService) RefreshAccessToken(refreshTokenStr string, client models.ClientInfo) (string, string, error) {
+	refreshToken, err := s.consumeRefreshToken(refreshTokenStr, 0, client)
+	if err != nil {
+		return "", "", err
 	}
 
 	user, err := s.userRepo.FindByID(refreshToken.UserID)
 	if err != nil {
-		return "", errors.New("user not found")
+		return "", "", errors.New("user not found")
+	}
+
+	sessionID, err := s.resumeSession(refreshToken, client)
+	if err != nil {
+		return "", "", err
+	}
+
+	newAccessToken, err := s.generateAccessToken(user.ID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+
+	newRefreshToken, err := s.generateRefreshToken(&models.RefreshToken{
+		UserID:    user.ID,
+		SessionID: optionalID(sessionID),
+		FamilyID:  refreshToken.FamilyID,
+		ParentID:  &refreshToken.ID,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditTokenRefreshed, UserID: user.ID, Client: client, Details: map[string]any{"family_id": refreshToken.FamilyID, "session_id": sessionID}})
+
+	return newAccessToken, newRefreshToken, nil
+}
+
+// consumeRefreshToken marks a refresh token as used so it can be rotated.
+// clientID is the OAuth client the token must have been issued to, or 0 for
+// our own clients.
+func (s *AuthService) consumeRefreshToken(refreshTokenStr string, clientID uint, client models.ClientInfo) (*models.RefreshToken, error) {
+	refreshToken, err := s.authRepo.FindRefreshToken(refreshTokenStr)
+	if err != nil || refreshToken.ExpiresAt.Before(time.Now()) || refreshToken.RevokedAt != nil || valueOf(refreshToken.ClientID) != clientID {
+		return nil, errors.New("invalid or expired refresh token")
+	}
+
+	if refreshToken.UsedAt != nil {
+		return nil, s.handleRefreshTokenReuse(refreshToken, client)
+	}
+
+	consumed, err := s.authRepo.ConsumeRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, s.handleRefreshTokenReuse(refreshToken, client)
+	}
+	return refreshToken, nil
+}
+
+func (s *AuthService) handleRefreshTokenReuse(refreshToken *models.RefreshToken, client models.ClientInfo) error {
+	if err := s.authRepo.RevokeRefreshTokenFamily(refreshToken.FamilyID); err != nil {
+		return err
+	}
+	if refreshToken.SessionID != nil {
+		if err := s.revokeSession(*refreshToken.SessionID); err != nil {
+			return err
+		}
+	}
+
+	event := &models.TokenReuseEvent{
+		UserID:   refreshToken.UserID,
+		FamilyID: refreshToken.FamilyID,
+		TokenID:  refreshToken.ID,
+	}
+	if err := s.authRepo.CreateTokenReuseEvent(event); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditTokenReuseDetected, UserID: refreshToken.UserID, Client: client, Details: map[string]string{"family_id": refreshToken.FamilyID}})
+
+	return errors.New("refresh token reuse detected")
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token and
+// returns its claims.
+func (s *AuthService) ParseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := s.keys.Parse(tokenString, claims)
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+func (s *AuthService) JWKS() models.JSONWebKeySet {
+	return s.keys.JWKS()
+}
+
+// IsAccessTokenActive reports whether the access token has not been revoked.
+// Results are cached for TOKEN_CACHE_TTL_SECONDS.
+func (s *AuthService) IsAccessTokenActive(token string) (bool, error) {
+	if active, ok := s.tokenCache.get(token); ok {
+		return active, nil
+	}
+
+	accessToken, err := s.authRepo.FindAccessToken(token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.tokenCache.set(token, 0, 0, false)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var sessionID uint
+	if accessToken.SessionID != nil {
+		sessionID = *accessToken.SessionID
+	}
+
+	active := accessToken.ExpiresAt.After(time.Now())
+	s.tokenCache.set(token, accessToken.UserID, sessionID, active)
+
+	// The session's last use is only as precise as the cache TTL, which
+	// saves a write on every request.
+	if active && sessionID != 0 {
+		if err := s.sessions.Touch(sessionID, models.ClientInfo{}); err != nil {
+			log.Printf("Failed to update session %d: %v", sessionID, err)
+		}
+	}
+	return active, nil
+}
+
+// Logout ends the current session, or, for tokens issued before sessions
+// existed, revokes the given access token and the refresh token family. A
+// refresh token that is not the user's is rejected before anything is
+// revoked, and the rest is revoked together or not at all.
+func (s *AuthService) Logout(userID uint, sessionID uint, accessToken string, refreshTokenStr string) error {
+	var refreshToken *models.RefreshToken
+	if refreshTokenStr != "" {
+		found, err := s.authRepo.FindRefreshToken(refreshTokenStr)
+		if err != nil || found.UserID != userID {
+			return errors.New("invalid refresh token")
+		}
+		refreshToken = found
+	}
+
+	err := s.tx.Transaction(func(tx *gorm.DB) error {
+		if sessionID != 0 {
+			if err := s.revokeSessionTx(tx, sessionID); err != nil {
+				return err
+			}
+		}
+
+		authRepo := s.authRepo.WithTx(tx)
+		if err := authRepo.DeleteAccessToken(accessToken); err != nil {
+			return err
+		}
+		if refreshToken == nil {
+			return nil
+		}
+		return authRepo.RevokeRefreshTokenFamily(refreshToken.FamilyID)
+	})
+	if err != nil {
+		return err
+	}
+
+	if sessionID != 0 {
+		s.tokenCache.revokeSession(sessionID)
+	}
+	s.tokenCache.revoke(accessToken)
+	return nil
+}
+
+// LogoutAll ends every session and revokes every access and refresh token of
+// the user.
+func (s *AuthService) LogoutAll(userID uint) error {
+	if err := s.tx.Transaction(func(tx *gorm.DB) error { return s.revokeUserTokens(tx, userID) }); err != nil {
+		return err
+	}
+
+	s.tokenCache.revokeUser(userID)
+	return nil
+}
+
+// RevokeAllTokens is LogoutAll on behalf of an administrator, and records it.
+func (s *AuthService) RevokeAllTokens(userID uint, client models.ClientInfo) error {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return errors.New("user not found")
+	}
+
+	if err := s.LogoutAll(userID); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditTokensRevoked, UserID: userID, Client: client})
+	return nil
+}
+
+// ForcePasswordReset clears the user's password, signs them out everywhere
+// and emails them a reset link. They cannot log in with a password until they
+// choose a new one.
+func (s *AuthService) ForcePasswordReset(userID uint, client models.ClientInfo) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	resetToken := &models.ResetToken{
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	msg, err := newOutboxMessage(OutboxPasswordResetEmail, emailPayload{To: user.Email, Token: token})
+	if err != nil {
+		return err
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.userRepo.WithTx(tx).UpdatePassword(user.ID, ""); err != nil {
+			return err
+		}
+		if err := s.revokeUserTokens(tx, user.ID); err != nil {
+			return err
+		}
+		if err := s.authRepo.WithTx(tx).CreateResetToken(resetToken); err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditPasswordResetForced, UserID: user.ID, Client: client}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.tokenCache.revokeUser(user.ID)
+	return nil
+}
+
+func (s *AuthService) revokeUserTokens(tx *gorm.DB, userID uint) error {
+	if err := s.sessions.WithTx(tx).RevokeByUserID(userID); err != nil {
+		return err
+	}
+
+	authRepo := s.authRepo.WithTx(tx)
+	if err := authRepo.DeleteAccessTokensByUserID(userID); err != nil {
+		return err
+	}
+	return authRepo.RevokeRefreshTokensByUserID(userID)
+}
+
+// ListSessions returns the user's active sessions with the one making the
+// request marked as current.
+func (s *AuthService) ListSessions(userID uint, currentSessionID uint) ([]models.Session, error) {
+	sessions, err := s.sessions.FindActiveByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the user's sessions. Its access tokens stop
+// working immediately and its refresh tokens can no longer be used.
+func (s *AuthService) RevokeSession(userID uint, sessionID uint, client models.ClientInfo) error {
+	session, err := s.sessions.FindByID(sessionID)
+	if err != nil || session.UserID != userID || session.RevokedAt != nil {
+		return errors.New("session not found")
+	}
+
+	if err := s.revokeSession(session.ID); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditSessionRevoked, UserID: userID, Client: client, Details: map[string]uint{"session_id": session.ID}})
+	return nil
+}
+
+func (s *AuthService) startSession(userID uint, client models.ClientInfo) (*models.Session, error) {
+	now := time.Now()
+	session := &models.Session{
+		UserID:     userID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(s.refreshTokenLifetime()),
+	}
+
+	if err := s.sessions.Create(session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// resumeSession returns the session of a refresh token being rotated and
+// records its use. Refresh tokens issued before sessions existed get a new
+// session.
+func (s *AuthService) resumeSession(refreshToken *models.RefreshToken, client models.ClientInfo) (uint, error) {
+	if refreshToken.SessionID == nil {
+		session, err := s.startSession(refreshToken.UserID, client)
+		if err != nil {
+			return 0, err
+		}
+		return session.ID, nil
+	}
+
+	session, err := s.sessions.FindByID(*refreshToken.SessionID)
+	if err != nil || session.RevokedAt != nil {
+		return 0, errors.New("invalid or expired refresh token")
+	}
+
+	if err := s.sessions.Touch(session.ID, client); err != nil {
+		return 0, err
+	}
+	if err := s.sessions.Extend(session.ID, time.Now().Add(s.refreshTokenLifetime())); err != nil {
+		return 0, err
+	}
+	return session.ID, nil
+}
+
+func (s *AuthService) revokeSession(sessionID uint) error {
+	if err := s.tx.Transaction(func(tx *gorm.DB) error { return s.revokeSessionTx(tx, sessionID) }); err != nil {
+		return err
+	}
+
+	s.tokenCache.revokeSession(sessionID)
+	return nil
+}
+
+// revokeSessionTx revokes the session and its tokens in tx. The caller must
+// drop the session from the token cache once tx commits.
+func (s *AuthService) revokeSessionTx(tx *gorm.DB, sessionID uint) error {
+	if err := s.sessions.WithTx(tx).Revoke(sessionID); err != nil {
+		return err
+	}
+
+	authRepo := s.authRepo.WithTx(tx)
+	if err := authRepo.DeleteAccessTokensBySessionID(sessionID); err != nil {
+		return err
+	}
+	return authRepo.RevokeRefreshTokensBySessionID(sessionID)
+}
+
+// ClientGrant describes the tokens an OAuth client is issued. UserID is 0 for
+// the client_credentials grant, whose tokens act for the client itself and
+// are only accepted by client-scoped endpoints. Nonce is echoed in the ID
+// token.
+type ClientGrant struct {
+	UserID uint
+	Client *models.OAuthClient
+	Scope  string
+	Nonce  string
+}
+
+// IssueClientTokens signs an access token for an OAuth client and, if
+// withRefresh is set, a refresh token the client can rotate at /oauth/token.
+// Grants for a user with the openid scope also get an ID token.
+func (s *AuthService) IssueClientTokens(grant ClientGrant, withRefresh bool) (*models.TokenResponse, error) {
+	accessToken, err := s.generateClientAccessToken(grant)
+	if err != nil {
+		return nil, err
 	}
 
-	newAccessToken, err := s.generateAccessToken(user.ID)
+	idToken, err := s.generateIDToken(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.accessTokenLifetime().Seconds()),
+		IDToken:     idToken,
+		Scope:       grant.Scope,
+	}
+
+	if withRefresh {
+		response.RefreshToken, err = s.generateRefreshToken(&models.RefreshToken{
+			UserID:   grant.UserID,
+			ClientID: &grant.Client.ID,
+			Scope:    grant.Scope,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+// RefreshClientTokens rotates a refresh token issued to oauthClient. scope
+// may narrow the access token to part of the original grant; the new
+// refresh token keeps the full grant.
+func (s *AuthService) RefreshClientTokens(refreshTokenStr string, oauthClient *models.OAuthClient, scope string, client models.ClientInfo) (*models.TokenResponse, error) {
+	if scope != "" {
+		existing, err := s.authRepo.FindRefreshToken(refreshTokenStr)
+		if err == nil && !scopeSubset(scope, existing.Scope) {
+			return nil, &OAuthError{Code: "invalid_scope", Description: "scope exceeds the original grant"}
+		}
+	}
+
+	refreshToken, err := s.consumeRefreshToken(refreshTokenStr, oauthClient.ID, client)
+	if err != nil {
+		return nil, err
+	}
+	if scope == "" {
+		scope = refreshToken.Scope
+	}
+
+	if _, err := s.userRepo.FindByID(refreshToken.UserID); err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	grant := ClientGrant{UserID: refreshToken.UserID, Client: oauthClient, Scope: scope}
+	accessToken, err := s.generateClientAccessToken(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	idToken, err := s.generateIDToken(grant)
+	if err != nil {
+		return nil, err
+	}
+
+	newRefreshToken, err := s.generateRefreshToken(&models.RefreshToken{
+		UserID:   refreshToken.UserID,
+		ClientID: &oauthClient.ID,
+		Scope:    refreshToken.Scope,
+		FamilyID: refreshToken.FamilyID,
+		ParentID: &refreshToken.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditTokenRefreshed, UserID: refreshToken.UserID, Client: client, Details: map[string]string{"family_id": refreshToken.FamilyID, "client_id": oauthClient.ClientID}})
+
+	return &models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.accessTokenLifetime().Seconds()),
+		RefreshToken: newRefreshToken,
+		IDToken:      idToken,
+		Scope:        scope,
+	}, nil
+}
+
+// generateClientAccessToken signs a token for an OAuth client. It carries the
+// granted scopes rather than the user's roles, and the client as audience.
+func (s *AuthService) generateClientAccessToken(grant ClientGrant) (string, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.accessTokenLifetime())
+
+	claims := jwt.MapClaims{
+		"sub":       grant.Client.ClientID,
+		"aud":       grant.Client.ClientID,
+		"client_id": grant.Client.ClientID,
+		"scope":     grant.Scope,
+		"iat":       now.Unix(),
+		"exp":       expiresAt.Unix(),
+	}
+	if grant.UserID != 0 {
+		claims["sub"] = strconv.FormatUint(uint64(grant.UserID), 10)
+		claims["user_id"] = grant.UserID
+	}
+	if issuer := oauthIssuer(s.cfg); issuer != "" {
+		claims["iss"] = issuer
+	}
+
+	signedToken, err := s.keys.Sign(claims)
+	if err != nil {
+		return "", err
+	}
+
+	accessToken := &models.AccessToken{
+		UserID:    grant.UserID,
+		ClientID:  &grant.Client.ID,
+		Token:     signedToken,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.authRepo.CreateAccessToken(accessToken); err != nil {
+		return "", err
+	}
+
+	return signedToken, nil
+}
+
+// RevokeClientTokens revokes what was issued to the OAuth client, for one
+// user or, with userID 0, for everyone.
+func (s *AuthService) RevokeClientTokens(clientID uint, userID uint) error {
+	if err := s.authRepo.DeleteAccessTokensByClientID(clientID, userID); err != nil {
+		return err
+	}
+	s.tokenCache.clear()
+
+	return s.authRepo.RevokeRefreshTokensByClientID(clientID, userID)
+}
+
+// generateIDToken returns an OpenID Connect ID token for grants to a user
+// with the openid scope, and an empty string otherwise. It carries the claims
+// /userinfo would return for the same scopes.
+func (s *AuthService) generateIDToken(grant ClientGrant) (string, error) {
+	if grant.UserID == 0 || !hasScope(grant.Scope, ScopeOpenID) {
+		return "", nil
+	}
+
+	user, err := s.userRepo.FindByID(grant.UserID)
 	if err != nil {
 		return "", err
 	}
 
-	return newAccessToken, nil
+	now := time.Now()
+	claims := jwt.MapClaims(userClaims(user, grant.Scope))
+	claims["iss"] = oauthIssuer(s.cfg)
+	claims["aud"] = grant.Client.ClientID
+	claims["azp"] = grant.Client.ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(s.accessTokenLifetime()).Unix()
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+
+	// Clients verify ID tokens against the JWKS, which an HS256 signature
+	// with the access token secret would not be.
+	return s.keys.SignAsymmetric(claims)
+}
+
+func (s *AuthService) accessTokenLifetime() time.Duration {
+	return time.Minute * time.Duration(s.cfg.Auth.AccessTokenExpiryMinutes)
+}
+
+func (s *AuthService) refreshTokenLifetime() time.Duration {
+	return time.Hour * 24 * time.Duration(s.cfg.Auth.RefreshTokenExpiryDays)
 }
 
 func generateRandomToken(length int) (string, error) {
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+	"skyphin-api/pkg/password"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+func newLogoutTestService(t *testing.T, db *gorm.DB) *AuthService {
+	t.Helper()
+	return &AuthService{
+		authRepo:   repositories.NewAuthRepository(db, newTestHasher(t)),
+		sessions:   repositories.NewSessionRepository(db),
+		tx:         repositories.NewTransactor(db),
+		tokenCache: newTokenCache(time.Minute),
+	}
+}
+
+func expectRefreshToken(mock sqlmock.Sqlmock, userID uint, familyID string) {
+	mock.ExpectQuery(`FROM "refresh_tokens" WHERE token_hash = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at"}).
+			AddRow(7, userID, familyID, time.Now().Add(time.Hour)))
+}
+
+func TestLogoutRejectsForeignRefreshTokenBeforeRevoking(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := newLogoutTestService(t, db)
+
+	// Nothing but the lookup: the session and access token stay valid.
+	expectRefreshToken(mock, 2, "family")
+
+	if err := s.Logout(1, 3, "access", "refresh"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLogoutRevokesInOneTransaction(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := newLogoutTestService(t, db)
+
+	expectRefreshToken(mock, 1, "family")
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "sessions" SET "revoked_at"=\$1 WHERE id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "access_tokens" WHERE session_id = \$1`).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE session_id = \$2`).
+		WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM "access_tokens" WHERE token_hash = \$1`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`UPDATE "refresh_tokens" SET "revoked_at"=\$1 WHERE family_id = \$2`).
+		WithArgs(sqlmock.AnyArg(), "family").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := s.Logout(1, 3, "access", "refresh"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLoginWithoutPasswordFailsLikeAWrongPassword covers users created by a
+// federated login and users whose password an admin reset: their empty hash
+// must be an ordinary failed login, not an error that tells them apart.
+func TestLoginWithoutPasswordFailsLikeAWrongPassword(t *testing.T) {
+	db, mock := newMockDB(t)
+	hasher, err := password.New(config.PasswordConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := repositories.NewUserRepository(db)
+	audit := NewAuditService(repositories.NewAuditRepository(db))
+	s := &AuthService{
+		userRepo:  userRepo,
+		lockout:   NewLockoutService(userRepo, repositories.NewLockoutRepository(db, newTestHasher(t)), nil, nil, audit, config.Config{}),
+		passwords: NewPasswordService(userRepo, hasher, nil, config.Config{}),
+		audit:     audit,
+	}
+
+	mock.ExpectQuery(`FROM "login_failures" WHERE email = \$1`).WillReturnRows(sqlmock.NewRows([]string{"count", "last"}).AddRow(0, nil))
+	mock.ExpectQuery(`FROM "login_failures" WHERE ip = \$1`).WillReturnRows(sqlmock.NewRows([]string{"count", "last"}).AddRow(0, nil))
+	mock.ExpectQuery(`FROM "users" WHERE lower\(email\) = lower\(\$1\)`).WithArgs("jane@example.com", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "verified", "encrypted_password"}).AddRow(42, "jane@example.com", true, ""))
+	mock.ExpectQuery(`UPDATE "users" SET "failed_logins"=.* RETURNING "failed_logins","failed_logins_since"`).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_logins", "failed_logins_since"}).AddRow(1, time.Now()))
+	details := expectAudit(mock, AuditLoginFailed, 42)
+	mock.ExpectQuery(`INSERT INTO "login_failures"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	_, err = s.Login(&models.LoginRequest{Email: "jane@example.com", Password: "guess"}, models.ClientInfo{IP: "192.0.2.1"})
+	if err == nil || err.Error() != "invalid email or password" {
+		t.Fatalf("err = %v, want invalid email or password", err)
+	}
+	if got := string(details.bytes()); got != `{"email":"jane@example.com","reason":"wrong_password"}` {
+		t.Errorf("audit details = %s", got)
+	}
+}
+
+func TestRegisterReportsAnEmailTakenSinceItWasChecked(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := &AuthService{userRepo: repositories.NewUserRepository(db), tx: repositories.NewTransactor(db)}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "users"`).
+		WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "idx_users_email_lower"})
+	mock.ExpectRollback()
+
+	err := s.Register(&models.User{Username: "jane", Email: "Jane@example.com"})
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("err = %v, want ErrEmailTaken", err)
+	}
+}
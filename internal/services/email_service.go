@@ -1,19 +1,89 @@
 package services
 
-import "skyphin-api/internal/config"
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"net/url"
+	"strings"
+	texttemplate "text/template"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/pkg/mailer"
+)
+
+//go:embed templates/email
+var emailTemplateFS embed.FS
+
+var (
+	htmlEmailTemplates = htmltemplate.Must(htmltemplate.ParseFS(emailTemplateFS, "templates/email/*.html"))
+	textEmailTemplates = texttemplate.Must(texttemplate.ParseFS(emailTemplateFS, "templates/email/*.txt"))
+)
+
+type emailData struct {
+	Token string
+	Link  string
+}
+
+type invitationEmailData struct {
+	emailData
+	Organization string
+}
 
 type EmailService struct {
-	cfg config.Config
+	mailer mailer.Mailer
+	cfg    config.Config
 }
 
-func NewEmailService(cfg config.Config) *EmailService {
-	return &EmailService{cfg: cfg}
+func NewEmailService(m mailer.Mailer, cfg config.Config) *EmailService {
+	return &EmailService{mailer: m, cfg: cfg}
 }
 
 func (s *EmailService) SendVerificationEmail(email string, token string) error {
-	return nil
+	data := emailData{Token: token, Link: s.link("/verify", token)}
+	return s.send(email, "Verify your email address", "verification", data)
 }
 
 func (s *EmailService) SendPasswordResetEmail(email string, token string) error {
-	return nil
+	data := emailData{Token: token, Link: s.link("/reset-password", token)}
+	return s.send(email, "Reset your password", "password_reset", data)
+}
+
+func (s *EmailService) SendAccountLockedEmail(email string, token string) error {
+	data := emailData{Token: token, Link: s.link("/unlock", token)}
+	return s.send(email, "Your account has been locked", "account_locked", data)
+}
+
+// SendMagicLinkEmail sends a sign-in link carrying token, with code as an
+// alternative to typing in.
+func (s *EmailService) SendMagicLinkEmail(email string, token string, code string) error {
+	data := emailData{Token: code, Link: s.link("/login/magic-link", token)}
+	return s.send(email, "Your Skyphin sign-in link", "magic_link", data)
+}
+
+func (s *EmailService) SendOrganizationInvitationEmail(email string, token string, organization string) error {
+	data := invitationEmailData{emailData: emailData{Token: token, Link: s.link("/invitations", token)}, Organization: organization}
+	return s.send(email, "You have been invited to join "+organization, "organization_invitation", data)
+}
+
+// send renders the HTML and text templates named after the message type.
+func (s *EmailService) send(to string, subject string, name string, data any) error {
+	var html, text bytes.Buffer
+	if err := htmlEmailTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return err
+	}
+	if err := textEmailTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return err
+	}
+
+	return s.mailer.Send(&mailer.Message{
+		To:      to,
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	})
+}
+
+func (s *EmailService) link(path string, token string) string {
+	return strings.TrimRight(s.cfg.Server.PublicURL, "/") + path + "?token=" + url.QueryEscape(token)
 }
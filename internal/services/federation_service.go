@@ -0,0 +1,308 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+	"skyphin-api/pkg/federation"
+
+	"gorm.io/gorm"
+)
+
+const (
+	federatedLoginTimeout   = 10 * time.Minute
+	federatedLoginCodeTTL   = time.Minute
+	FederatedLoginStateName = "federated_login_state"
+	maxUsernameAttempts     = 5
+)
+
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// FederationService signs users in with external identity providers. An
+// external account is linked to the user with the same verified email, or a
+// new user is created for it.
+type FederationService struct {
+	connectors   map[string]federation.Connector
+	names        []string
+	userRepo     *repositories.UserRepository
+	identityRepo *repositories.IdentityRepository
+	tx           *repositories.Transactor
+	lockout      *LockoutService
+	audit        *AuditService
+	cfg          config.Config
+}
+
+func NewFederationService(userRepo *repositories.UserRepository, identityRepo *repositories.IdentityRepository, tx *repositories.Transactor, lockout *LockoutService, audit *AuditService, cfg config.Config) (*FederationService, error) {
+	s := &FederationService{
+		connectors:   make(map[string]federation.Connector),
+		userRepo:     userRepo,
+		identityRepo: identityRepo,
+		tx:           tx,
+		lockout:      lockout,
+		audit:        audit,
+		cfg:          cfg,
+	}
+
+	for _, provider := range cfg.Federation.Providers {
+		connector, err := federation.New(provider, s.callbackURL(provider.Name))
+		if err != nil {
+			return nil, err
+		}
+		s.connectors[provider.Name] = connector
+		s.names = append(s.names, provider.Name)
+	}
+
+	return s, nil
+}
+
+func (s *FederationService) Providers() []string {
+	return s.names
+}
+
+// Begin starts a sign-in with the provider. It returns the URL to send the
+// browser to and the state, which must also be kept in a cookie to tie the
+// callback to the same browser.
+func (s *FederationService) Begin(ctx context.Context, provider string) (string, string, error) {
+	connector, ok := s.connectors[provider]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	login := &models.FederatedLogin{Provider: provider, ExpiresAt: time.Now().Add(federatedLoginTimeout)}
+	for _, field := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		value, err := randomHex(32)
+		if err != nil {
+			return "", "", err
+		}
+		*field = value
+	}
+
+	authURL, err := connector.AuthCodeURL(ctx, login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.identityRepo.CreateLogin(login); err != nil {
+		return "", "", err
+	}
+	return authURL, login.State, nil
+}
+
+// Callback completes the sign-in after the provider redirects back and
+// returns a short-lived login code for the web app to exchange for tokens.
+func (s *FederationService) Callback(ctx context.Context, provider string, code string, state string, cookieState string, client models.ClientInfo) (string, error) {
+	connector, ok := s.connectors[provider]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		return "", errors.New("sign-in was started in another browser")
+	}
+
+	login, err := s.identityRepo.FindLoginByState(state)
+	if err != nil || login.Provider != provider || login.UserID != nil || login.ExpiresAt.Before(time.Now()) {
+		return "", errors.New("sign-in expired, please try again")
+	}
+
+	external, err := connector.Exchange(ctx, code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		log.Printf("Federated login with %s failed: %v", provider, err)
+		return "", errors.New("sign-in with the provider failed")
+	}
+
+	user, err := s.resolveUser(provider, external, client)
+	if err != nil {
+		return "", err
+	}
+	if s.lockout.IsLocked(user) {
+		return "", ErrAccountLocked
+	}
+
+	loginCode, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	login.UserID = &user.ID
+	login.LoginCode = &loginCode
+	login.ExpiresAt = time.Now().Add(federatedLoginCodeTTL)
+	if err := s.identityRepo.UpdateLogin(login); err != nil {
+		return "", err
+	}
+
+	return loginCode, nil
+}
+
+// Exchange redeems a login code from Callback, once, and returns the user.
+func (s *FederationService) Exchange(loginCode string) (*models.User, error) {
+	invalid := errors.New("invalid or expired login code")
+
+	login, err := s.identityRepo.FindLoginByCode(loginCode)
+	if err != nil || login.UserID == nil {
+		return nil, invalid
+	}
+
+	deleted, err := s.identityRepo.DeleteLogin(login.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted || login.ExpiresAt.Before(time.Now()) {
+		return nil, invalid
+	}
+
+	return s.userRepo.FindByID(*login.UserID)
+}
+
+// FrontendURL is the page of the web app that receives the result of a
+// sign-in: a login code or an error message.
+func (s *FederationService) FrontendURL(loginCode string, err error) string {
+	params := url.Values{}
+	if err != nil {
+		params.Set("error", err.Error())
+	} else {
+		params.Set("code", loginCode)
+	}
+	return strings.TrimRight(s.cfg.Server.PublicURL, "/") + "/login/federated/complete?" + params.Encode()
+}
+
+// SecureCookies reports whether the state cookie should be limited to HTTPS.
+func (s *FederationService) SecureCookies() bool {
+	return secureCookies(s.cfg)
+}
+
+func (s *FederationService) ListIdentities(userID uint) ([]models.Identity, error) {
+	return s.identityRepo.FindByUserID(userID)
+}
+
+// Unlink removes a linked login, unless it is the only way left to sign in.
+func (s *FederationService) Unlink(userID uint, id uint, client models.ClientInfo) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+
+	identities, err := s.identityRepo.FindByUserID(userID)
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(identities, func(identity models.Identity) bool { return identity.ID == id })
+	if index < 0 {
+		return errors.New("identity not found")
+	}
+	if user.EncryptedPassword == "" && len(identities) == 1 {
+		return errors.New("set a password before removing your last linked login")
+	}
+
+	if _, err := s.identityRepo.Delete(userID, id); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditIdentityUnlinked, UserID: userID, Client: client, Details: map[string]string{"provider": identities[index].Provider}})
+	return nil
+}
+
+// resolveUser finds the user linked to the external account. Otherwise it
+// links the account to the user with the same email, if the provider has
+// verified it, or creates a new user.
+func (s *FederationService) resolveUser(provider string, external *federation.Identity, client models.ClientInfo) (*models.User, error) {
+	identity, err := s.identityRepo.FindByProviderSubject(provider, external.Subject)
+	if err == nil {
+		if err := s.identityRepo.TouchLastLogin(identity.ID, external.Email); err != nil {
+			return nil, err
+		}
+		return s.userRepo.FindByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if external.Email == "" || !external.EmailVerified {
+		return nil, errors.New("the provider did not share a verified email address")
+	}
+
+	now := time.Now()
+	identity = &models.Identity{Provider: provider, Subject: external.Subject, Email: external.Email, LastLoginAt: &now}
+
+	user, err := s.userRepo.FindByEmail(external.Email)
+	switch {
+	case err == nil:
+		// Linking to an account whose owner never proved the address would
+		// let whoever registered it sign in as the provider's user.
+		if !user.Verified {
+			return nil, errors.New("an unverified account uses this email, verify it first")
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		username, err := s.availableUsername(usernameFor(external))
+		if err != nil {
+			return nil, err
+		}
+		user = &models.User{Username: username, Email: external.Email, Verified: true}
+	default:
+		return nil, err
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		if user.ID == 0 {
+			if err := s.userRepo.WithTx(tx).Create(user); err != nil {
+				return err
+			}
+		}
+
+		identity.UserID = user.ID
+		if err := s.identityRepo.WithTx(tx).Create(identity); err != nil {
+			return err
+		}
+
+		return s.audit.RecordTx(tx, AuditEntry{Type: AuditIdentityLinked, UserID: user.ID, Client: client, Details: map[string]string{"provider": provider}})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (s *FederationService) callbackURL(provider string) string {
+	return fmt.Sprintf("%s/login/federated/%s/callback", oauthIssuer(s.cfg), url.PathEscape(provider))
+}
+
+// availableUsername returns name, or name with a random suffix if a user
+// already has it. The unique index still catches a concurrent sign-up.
+func (s *FederationService) availableUsername(name string) (string, error) {
+	candidate := name
+	for range maxUsernameAttempts {
+		_, err := s.userRepo.FindByUsername(candidate)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		suffix, err := randomHex(3)
+		if err != nil {
+			return "", err
+		}
+		candidate = name + "-" + suffix
+	}
+	return "", errors.New("could not find a free username")
+}
+
+func usernameFor(external *federation.Identity) string {
+	if external.Username != "" {
+		return external.Username
+	}
+	local, _, _ := strings.Cut(external.Email, "@")
+	return local
+}
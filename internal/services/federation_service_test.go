@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAvailableUsernameAddsSuffixWhenTaken(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := &FederationService{userRepo: repositories.NewUserRepository(db)}
+
+	byUsername := `FROM "users" WHERE username = \$1`
+	mock.ExpectQuery(byUsername).WithArgs("jane", 1).
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(1, "jane", "jane@example.com", true))
+	mock.ExpectQuery(byUsername).WithArgs(sqlmock.AnyArg(), 1).WillReturnRows(sqlmock.NewRows(userColumns))
+
+	username, err := s.availableUsername("jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username == "jane" || len(username) != len("jane-")+6 || username[:5] != "jane-" {
+		t.Errorf("username = %q, want jane with a suffix", username)
+	}
+}
+
+func TestAvailableUsernameKeepsFreeName(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := &FederationService{userRepo: repositories.NewUserRepository(db)}
+
+	mock.ExpectQuery(`FROM "users" WHERE username = \$1`).WithArgs("jane", 1).WillReturnRows(sqlmock.NewRows(userColumns))
+
+	username, err := s.availableUsername("jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "jane" {
+		t.Errorf("username = %q, want jane", username)
+	}
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyReloadInterval = time.Minute
+
+// JWKSMaxAge is how long verifiers may cache the JWKS.
+const JWKSMaxAge = 5 * time.Minute
+
+// keyPublishDelay is how long a new key waits before it signs: until every
+// instance has loaded it and every cached JWKS lists it.
+const keyPublishDelay = keyReloadInterval + JWKSMaxAge
+
+// ErrNoAsymmetricKey is returned when a token that others must be able to
+// verify, such as an ID token, is to be signed while no key files are
+// configured.
+var ErrNoAsymmetricKey = errors.New("no asymmetric signing key configured")
+
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	// modifiedAt is the key file's modification time, which every instance
+	// agrees on.
+	modifiedAt time.Time
+	removedAt  time.Time
+}
+
+// KeyManager holds the asymmetric keys used to sign and verify JWTs.
+//
+// Keys are read from SIGNING_KEY_FILES, and every configured key verifies.
+// Tokens are signed with the newest key that has been published for long
+// enough, so keys are rotated by adding a file. A key whose file is removed
+// from the list keeps verifying until the tokens it signed have expired. When no files are
+// configured tokens are signed with HS256 and ACCESS_TOKEN_SECRET. Once keys
+// are configured HS256 tokens are rejected, unless ACCEPT_LEGACY_HS256 is set
+// to keep those issued before the switch verifying. ID tokens are never
+// signed with HS256.
+type KeyManager struct {
+	mu         sync.RWMutex
+	cfg        config.AuthConfig
+	keys       []*signingKey
+	removed    []*signingKey
+	hmacSecret []byte
+}
+
+func NewKeyManager(cfg config.AuthConfig) (*KeyManager, error) {
+	m := &KeyManager{cfg: cfg}
+	if cfg.AccessTokenSecret != "" {
+		m.hmacSecret = []byte(cfg.AccessTokenSecret)
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	if len(m.keys) == 0 && m.hmacSecret == nil {
+		return nil, errors.New("no signing keys or access token secret configured")
+	}
+
+	return m, nil
+}
+
+// Run reloads the key files periodically until ctx is cancelled, so keys can
+// be added or removed without a restart.
+func (m *KeyManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Reload reads every configured key file. Keys that are no longer configured
+// are kept for verification for the lifetime of an access token.
+func (m *KeyManager) Reload() error {
+	keys := make([]*signingKey, 0, len(m.cfg.SigningKeyFiles))
+	for _, file := range m.cfg.SigningKeyFiles {
+		key, err := loadSigningKey(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	grace := time.Minute * time.Duration(m.cfg.AccessTokenExpiryMinutes)
+
+	current := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		current[key.kid] = true
+	}
+
+	var removed []*signingKey
+	for _, key := range m.removed {
+		if !current[key.kid] && now.Sub(key.removedAt) < grace {
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range m.keys {
+		if !current[key.kid] {
+			key.removedAt = now
+			removed = append(removed, key)
+		}
+	}
+
+	m.keys = keys
+	m.removed = removed
+	return nil
+}
+
+// Sign signs the claims with the currently scheduled key.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.activeKey(time.Now())
+	m.mu.RUnlock()
+
+	if key == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.hmacSecret)
+	}
+
+	return key.sign(claims)
+}
+
+// SignAsymmetric is Sign without the HS256 fallback, for tokens verified by
+// other parties against the JWKS.
+func (m *KeyManager) SignAsymmetric(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.activeKey(time.Now())
+	m.mu.RUnlock()
+
+	if key == nil {
+		return "", ErrNoAsymmetricKey
+	}
+	return key.sign(claims)
+}
+
+// HasAsymmetricKey reports whether SignAsymmetric can sign.
+func (m *KeyManager) HasAsymmetricKey() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.keys) > 0
+}
+
+func (key *signingKey) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Parse verifies the token signature against the key named by its kid header.
+func (m *KeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, m.keyFunc)
+}
+
+func (m *KeyManager) keyFunc(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if m.hmacSecret == nil || (m.HasAsymmetricKey() && !m.cfg.AcceptLegacyHS256) {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return m.hmacSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := m.findKey(kid)
+	if key == nil || key.method.Alg() != token.Method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public half of every key that can still verify tokens.
+func (m *KeyManager) JWKS() models.JSONWebKeySet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := models.JSONWebKeySet{Keys: []models.JSONWebKey{}}
+	for _, key := range m.keys {
+		set.Keys = append(set.Keys, publicJWK(key))
+	}
+	for _, key := range m.removed {
+		set.Keys = append(set.Keys, publicJWK(key))
+	}
+	return set
+}
+
+// activeKey returns the newest key published before now less
+// keyPublishDelay, so that verifiers know it. If every key is newer than
+// that, the oldest is used.
+func (m *KeyManager) activeKey(now time.Time) *signingKey {
+	var active, oldest *signingKey
+	for _, key := range m.keys {
+		if oldest == nil || key.modifiedAt.Before(oldest.modifiedAt) {
+			oldest = key
+		}
+		if now.Sub(key.modifiedAt) < keyPublishDelay {
+			continue
+		}
+		if active == nil || !key.modifiedAt.Before(active.modifiedAt) {
+			active = key
+		}
+	}
+
+	if active == nil {
+		return oldest
+	}
+	return active
+}
+
+func (m *KeyManager) findKey(kid string) *signingKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, key := range m.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+	for _, key := range m.removed {
+		if key.kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+func loadSigningKey(file string) (*signingKey, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", file)
+	}
+
+	var private crypto.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	key := &signingKey{private: private, modifiedAt: info.ModTime()}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.public = jwt.SigningMethodRS256, &k.PublicKey
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			key.method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("%s: unsupported curve", file)
+		}
+		key.public = &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k.Public()
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", file, private)
+	}
+
+	key.kid = thumbprint(publicJWK(key))
+	return key, nil
+}
+
+func publicJWK(key *signingKey) models.JSONWebKey {
+	jwk := models.JSONWebKey{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// thumbprint computes the RFC 7638 thumbprint of the key, used as its kid.
+func thumbprint(jwk models.JSONWebKey) string {
+	var canonical string
+	switch jwk.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, jwk.E, jwk.N)
+	case "EC":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, jwk.Crv, jwk.X, jwk.Y)
+	case "OKP":
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, jwk.Crv, jwk.X)
+	}
+
+	sum := sha256.Sum256([]byte(canonical))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// SigningAlgorithms lists the asymmetric JWS algorithms tokens may be signed
+// with. HS256 is never listed, as no one else can verify it.
+func (m *KeyManager) SigningAlgorithms() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	algorithms := []string{}
+	for _, key := range m.keys {
+		if !slices.Contains(algorithms, key.method.Alg()) {
+			algorithms = append(algorithms, key.method.Alg())
+		}
+	}
+	return algorithms
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestActiveKeyWaitsUntilNewKeysArePublished(t *testing.T) {
+	now := time.Now()
+	old := &signingKey{kid: "old", modifiedAt: now.Add(-24 * time.Hour)}
+	published := &signingKey{kid: "published", modifiedAt: now.Add(-keyPublishDelay)}
+	fresh := &signingKey{kid: "fresh", modifiedAt: now.Add(-time.Minute)}
+
+	tests := []struct {
+		name string
+		keys []*signingKey
+		want *signingKey
+	}{
+		{"no keys", nil, nil},
+		{"newest published key", []*signingKey{published, old}, published},
+		{"new key not yet published", []*signingKey{old, fresh}, old},
+		{"only new keys", []*signingKey{fresh, &signingKey{kid: "fresher", modifiedAt: now}}, fresh},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &KeyManager{keys: tt.keys}
+			if got := m.activeKey(now); got != tt.want {
+				t.Errorf("activeKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLoginMaxFailures   = 5
+	defaultLoginFailureWindow = 15 * time.Minute
+	defaultLockoutDuration    = 15 * time.Minute
+	defaultLoginMaxIPFailures = 50
+	defaultLoginDelayBase     = time.Second
+	defaultLoginDelayMax      = 30 * time.Second
+)
+
+var ErrAccountLocked = errors.New("account temporarily locked")
+
+// LoginThrottledError is returned while a client has to wait before its next
+// login attempt.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return "too many failed login attempts, try again later"
+}
+
+type lockoutEventPayload struct {
+	UserID      uint       `json:"user_id"`
+	Email       string     `json:"email"`
+	IP          string     `json:"ip,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	UnlockedBy  string     `json:"unlocked_by,omitempty"`
+}
+
+// LockoutService tracks failed password logins. Each failure doubles the wait
+// before the next attempt for the same email, a busy IP is throttled across
+// all accounts, and too many failures lock the account until it expires or
+// is unlocked.
+type LockoutService struct {
+	userRepo      *repositories.UserRepository
+	lockoutRepo   *repositories.LockoutRepository
+	outboxRepo    *repositories.OutboxRepository
+	tx            *repositories.Transactor
+	audit         *AuditService
+	maxFailures   int
+	window        time.Duration
+	duration      time.Duration
+	maxIPFailures int
+	delayBase     time.Duration
+	delayMax      time.Duration
+}
+
+func NewLockoutService(userRepo *repositories.UserRepository, lockoutRepo *repositories.LockoutRepository, outboxRepo *repositories.OutboxRepository, tx *repositories.Transactor, audit *AuditService, cfg config.Config) *LockoutService {
+	return &LockoutService{
+		userRepo:      userRepo,
+		lockoutRepo:   lockoutRepo,
+		outboxRepo:    outboxRepo,
+		tx:            tx,
+		audit:         audit,
+		maxFailures:   config.IntOr(cfg.Auth.LoginMaxFailures, defaultLoginMaxFailures),
+		window:        config.MinutesOr(cfg.Auth.LoginFailureWindowMinutes, defaultLoginFailureWindow),
+		duration:      config.MinutesOr(cfg.Auth.LockoutDurationMinutes, defaultLockoutDuration),
+		maxIPFailures: config.IntOr(cfg.Auth.LoginMaxIPFailures, defaultLoginMaxIPFailures),
+		delayBase:     config.SecondsOr(cfg.Auth.LoginDelayBaseSeconds, defaultLoginDelayBase),
+		delayMax:      config.SecondsOr(cfg.Auth.LoginDelayMaxSeconds, defaultLoginDelayMax),
+	}
+}
+
+// CheckThrottle returns a LoginThrottledError if the email or IP has to wait
+// before trying again.
+func (s *LockoutService) CheckThrottle(email string, ip string) error {
+	since := time.Now().Add(-s.window)
+
+	emailStats, err := s.lockoutRepo.FailureStatsByEmail(email, since)
+	if err != nil {
+		return err
+	}
+	ipStats, err := s.lockoutRepo.FailureStatsByIP(ip, since)
+	if err != nil {
+		return err
+	}
+
+	wait := max(s.wait(emailStats, 0), s.wait(ipStats, s.maxIPFailures))
+	if wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+func (s *LockoutService) IsLocked(user *models.User) bool {
+	return user.LockedUntil != nil && user.LockedUntil.After(time.Now())
+}
+
+// ReserveAttempt counts a password attempt for user before the password is
+// checked. It returns ErrAccountLocked if the account is locked or has used
+// up its attempts, so that concurrent guesses cannot get past the limit.
+func (s *LockoutService) ReserveAttempt(user *models.User) error {
+	reserved, err := s.userRepo.ReserveLoginAttempt(user, s.maxFailures, time.Now().Add(-s.window))
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+// RecordFailure stores a failed login. user is nil when the email is unknown,
+// and otherwise had its attempt reserved. It returns ErrAccountLocked when
+// this failure locks the account.
+func (s *LockoutService) RecordFailure(email string, client models.ClientInfo, user *models.User) error {
+	if err := s.lockoutRepo.CreateFailure(&models.LoginFailure{Email: email, IP: client.IP}); err != nil {
+		return err
+	}
+	if user == nil || user.FailedLogins < s.maxFailures {
+		return nil
+	}
+
+	if err := s.lock(user, client); err != nil {
+		return err
+	}
+	return ErrAccountLocked
+}
+
+func (s *LockoutService) RecordSuccess(email string, userID uint) error {
+	if err := s.userRepo.ResetFailedLogins(userID); err != nil {
+		return err
+	}
+	return s.lockoutRepo.DeleteFailuresByEmail(email)
+}
+
+// Unlock lifts a lock on behalf of an admin.
+func (s *LockoutService) Unlock(userID uint, adminID uint, client models.ClientInfo) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	return s.unlock(user, "admin", adminID, client)
+}
+
+// UnlockWithToken lifts a lock using the token emailed to the account owner.
+func (s *LockoutService) UnlockWithToken(token string, client models.ClientInfo) error {
+	unlockToken, err := s.lockoutRepo.FindUnlockToken(token)
+	if err != nil || unlockToken.ExpiresAt.Before(time.Now()) {
+		return errors.New("invalid or expired token")
+	}
+
+	user, err := s.userRepo.FindByID(unlockToken.UserID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	return s.unlock(user, "email", 0, client)
+}
+
+// lock locks the account, emails the owner an unlock link and records an
+// account_locked event and audit entry, all in one transaction.
+func (s *LockoutService) lock(user *models.User, client models.ClientInfo) error {
+	until := time.Now().Add(s.duration)
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	email, err := newOutboxMessage(OutboxAccountLockedEmail, emailPayload{To: user.Email, Token: token})
+	if err != nil {
+		return err
+	}
+	event, err := newOutboxMessage(OutboxAccountLocked, lockoutEventPayload{UserID: user.ID, Email: user.Email, IP: client.IP, LockedUntil: &until})
+	if err != nil {
+		return err
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		lockoutRepo := s.lockoutRepo.WithTx(tx)
+		userRepo := s.userRepo.WithTx(tx)
+		if err := userRepo.SetLockedUntil(user.ID, &until); err != nil {
+			return err
+		}
+		if err := userRepo.ResetFailedLogins(user.ID); err != nil {
+			return err
+		}
+		if err := lockoutRepo.DeleteFailuresByEmail(user.Email); err != nil {
+			return err
+		}
+		if err := lockoutRepo.CreateUnlockToken(&models.UnlockToken{UserID: user.ID, Token: token, ExpiresAt: until}); err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditAccountLocked, UserID: user.ID, Client: client, Details: map[string]any{"locked_until": until}}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(email, event)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Locked user %d until %s after repeated failed logins from %s", user.ID, until.Format(time.RFC3339), client.IP)
+	return nil
+}
+
+func (s *LockoutService) unlock(user *models.User, unlockedBy string, actorID uint, client models.ClientInfo) error {
+	event, err := newOutboxMessage(OutboxAccountUnlocked, lockoutEventPayload{UserID: user.ID, Email: user.Email, UnlockedBy: unlockedBy})
+	if err != nil {
+		return err
+	}
+
+	return s.tx.Transaction(func(tx *gorm.DB) error {
+		lockoutRepo := s.lockoutRepo.WithTx(tx)
+		userRepo := s.userRepo.WithTx(tx)
+		if err := userRepo.SetLockedUntil(user.ID, nil); err != nil {
+			return err
+		}
+		if err := userRepo.ResetFailedLogins(user.ID); err != nil {
+			return err
+		}
+		if err := lockoutRepo.DeleteFailuresByEmail(user.Email); err != nil {
+			return err
+		}
+		if err := lockoutRepo.DeleteUnlockTokensByUserID(user.ID); err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditAccountUnlocked, UserID: user.ID, ActorID: actorID, Client: client, Details: map[string]string{"unlocked_by": unlockedBy}}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(event)
+	})
+}
+
+// wait returns how long to hold off after the failures beyond threshold. The
+// delay doubles with every failure up to delayMax and counts from the latest
+// one.
+func (s *LockoutService) wait(stats *repositories.FailureStats, threshold int) time.Duration {
+	excess := stats.Count - int64(threshold)
+	if excess <= 0 || stats.Last == nil {
+		return 0
+	}
+
+	delay := s.delayBase
+	for i := int64(1); i < excess && delay < s.delayMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, s.delayMax)
+
+	return max(time.Until(stats.Last.Add(delay)), 0)
+}
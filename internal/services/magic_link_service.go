@@ -0,0 +1,210 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	MagicLinkBindingCookie = "magic_link_binding"
+
+	defaultMagicLinkTTL         = 10 * time.Minute
+	defaultMagicLinkMaxAttempts = 5
+	magicLinkTokenType          = "magic_link"
+)
+
+var errInvalidMagicLink = errors.New("invalid or expired sign-in link or code")
+
+// MagicLinkService lets users sign in with a single-use link or a 6-digit
+// code sent to their email address. Both only work in the browser that asked
+// for them, which holds a binding secret in a cookie.
+type MagicLinkService struct {
+	userRepo    *repositories.UserRepository
+	authRepo    *repositories.AuthRepository
+	outboxRepo  *repositories.OutboxRepository
+	tx          *repositories.Transactor
+	lockout     *LockoutService
+	audit       *AuditService
+	keys        *KeyManager
+	cfg         config.Config
+	ttl         time.Duration
+	maxAttempts int
+}
+
+func NewMagicLinkService(userRepo *repositories.UserRepository, authRepo *repositories.AuthRepository, outboxRepo *repositories.OutboxRepository, tx *repositories.Transactor, lockout *LockoutService, audit *AuditService, keys *KeyManager, cfg config.Config) *MagicLinkService {
+	return &MagicLinkService{
+		userRepo:    userRepo,
+		authRepo:    authRepo,
+		outboxRepo:  outboxRepo,
+		tx:          tx,
+		lockout:     lockout,
+		audit:       audit,
+		keys:        keys,
+		cfg:         cfg,
+		ttl:         config.MinutesOr(cfg.Auth.MagicLinkTTLMinutes, defaultMagicLinkTTL),
+		maxAttempts: config.IntOr(cfg.Auth.MagicLinkMaxAttempts, defaultMagicLinkMaxAttempts),
+	}
+}
+
+// Request emails a sign-in link and code to the user and returns the binding
+// secret for the requesting browser. To not reveal which emails have an
+// account, it returns a binding even when nothing is sent.
+func (s *MagicLinkService) Request(email string, client models.ClientInfo) (string, error) {
+	binding, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := s.userRepo.FindByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return binding, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !user.Verified || s.lockout.IsLocked(user) {
+		return binding, nil
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	code, err := randomDigits(6)
+	if err != nil {
+		return "", err
+	}
+
+	magicLink := &models.MagicLinkToken{
+		UserID:    user.ID,
+		Token:     token,
+		Code:      code,
+		Binding:   sha256Hex(binding),
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+
+	link, err := s.keys.Sign(jwt.MapClaims{
+		"typ": magicLinkTokenType,
+		"jti": token,
+		"sub": fmt.Sprint(user.ID),
+		"exp": magicLink.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	msg, err := newOutboxMessage(OutboxMagicLinkEmail, magicLinkEmailPayload{To: user.Email, Token: link, Code: code})
+	if err != nil {
+		return "", err
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		// Only the latest link works, so each request cannot add more code
+		// attempts.
+		if err := s.authRepo.WithTx(tx).DeleteMagicLinkTokensByUserID(user.ID); err != nil {
+			return err
+		}
+		if err := s.authRepo.WithTx(tx).CreateMagicLinkToken(magicLink); err != nil {
+			return err
+		}
+		if err := s.audit.RecordTx(tx, AuditEntry{Type: AuditMagicLinkRequested, UserID: user.ID, Client: client}); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(msg)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return binding, nil
+}
+
+// Verify checks the link token or code against the pending login bound to
+// the browser and returns its user. A pending login can be used once, and
+// runs out after too many wrong codes.
+func (s *MagicLinkService) Verify(req *models.MagicLinkVerifyRequest, binding string, client models.ClientInfo) (*models.User, error) {
+	if binding == "" {
+		return nil, errors.New("open the link in the browser where you asked to sign in")
+	}
+
+	magicLink, err := s.authRepo.FindMagicLinkTokenByBinding(sha256Hex(binding))
+	if err != nil || magicLink.ExpiresAt.Before(time.Now()) {
+		return nil, errInvalidMagicLink
+	}
+
+	if req.Token != "" {
+		if !s.validLinkToken(req.Token, magicLink) {
+			return nil, errInvalidMagicLink
+		}
+	} else {
+		left, err := s.authRepo.UseMagicLinkAttempt(magicLink.ID, s.maxAttempts)
+		if err != nil {
+			return nil, err
+		}
+		if !left {
+			return nil, errInvalidMagicLink
+		}
+		if !s.authRepo.MagicLinkMatches(req.Code, magicLink.CodeHash) {
+			s.audit.Record(AuditEntry{Type: AuditLoginFailed, UserID: magicLink.UserID, Client: client, Details: map[string]string{"reason": "wrong_magic_link_code"}})
+			return nil, errInvalidMagicLink
+		}
+	}
+
+	deleted, err := s.authRepo.DeleteMagicLinkToken(magicLink.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !deleted {
+		return nil, errInvalidMagicLink
+	}
+
+	user, err := s.userRepo.FindByID(magicLink.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if s.lockout.IsLocked(user) {
+		return nil, ErrAccountLocked
+	}
+
+	return user, nil
+}
+
+// SecureCookies reports whether the binding cookie should be limited to
+// HTTPS.
+func (s *MagicLinkService) SecureCookies() bool {
+	return secureCookies(s.cfg)
+}
+
+func (s *MagicLinkService) CookieMaxAge() int {
+	return int(s.ttl.Seconds())
+}
+
+func (s *MagicLinkService) validLinkToken(link string, magicLink *models.MagicLinkToken) bool {
+	claims := jwt.MapClaims{}
+	token, err := s.keys.Parse(link, claims)
+	if err != nil || !token.Valid || claims["typ"] != magicLinkTokenType {
+		return false
+	}
+
+	jti, _ := claims["jti"].(string)
+	return s.authRepo.MagicLinkMatches(jti, magicLink.TokenHash)
+}
+
+func randomDigits(n int) (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	value, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n, value), nil
+}
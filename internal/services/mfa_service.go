@@ -0,0 +1,277 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+)
+
+const (
+	totpPeriod            = 30
+	totpDigits            = 6
+	totpSkew              = 1 // Accept codes from one step before or after the current one.
+	recoveryCodeCount     = 10
+	mfaChallengeExpiry    = 5 * time.Minute
+	mfaChallengeAttempts  = 5
+	defaultMFAIssuer      = "Skyphin"
+	recoveryCodeAlphabet  = "abcdefghijkmnpqrstuvwxyz23456789"
+	recoveryCodeHalfChars = 5
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type MFAService struct {
+	userRepo  *repositories.UserRepository
+	mfaRepo   *repositories.MFARepository
+	passwords *PasswordService
+	audit     *AuditService
+	cfg       config.Config
+}
+
+func NewMFAService(userRepo *repositories.UserRepository, mfaRepo *repositories.MFARepository, passwords *PasswordService, audit *AuditService, cfg config.Config) *MFAService {
+	return &MFAService{userRepo: userRepo, mfaRepo: mfaRepo, passwords: passwords, audit: audit, cfg: cfg}
+}
+
+// EnrollTOTP creates a new unconfirmed TOTP secret for the user. It only
+// takes effect once ConfirmTOTP succeeds.
+func (s *MFAService) EnrollTOTP(userID uint) (*models.TOTPEnrollment, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if user.MFAEnabled {
+		return nil, errors.New("MFA is already enabled")
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := base32NoPadding.EncodeToString(raw)
+
+	if err := s.mfaRepo.SaveTOTPSecret(&models.TOTPSecret{UserID: userID, Secret: secret}); err != nil {
+		return nil, err
+	}
+
+	return &models.TOTPEnrollment{Secret: secret, URI: s.otpauthURI(user, secret)}, nil
+}
+
+// ConfirmTOTP enables MFA once the user proves their authenticator works and
+// returns the one-time recovery codes. They are only shown this once.
+func (s *MFAService) ConfirmTOTP(userID uint, code string) ([]string, error) {
+	secret, err := s.mfaRepo.FindTOTPSecret(userID)
+	if err != nil || secret.ConfirmedAt != nil {
+		return nil, errors.New("no pending TOTP enrollment")
+	}
+
+	if ok, err := s.verifyTOTP(secret, code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errors.New("invalid code")
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		codes[i], err = generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		records[i] = models.RecoveryCode{UserID: userID, Code: normalizeRecoveryCode(codes[i])}
+	}
+
+	if err := s.mfaRepo.ConfirmTOTPSecret(secret, records); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns MFA off after checking the current password and a
+// current code or recovery code, so that a stolen session alone cannot.
+func (s *MFAService) DisableTOTP(userID uint, req *models.DisableMFARequest) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	// Verify reports whether to rehash, not whether the password matched.
+	if _, err := s.passwords.Verify(req.Password, user.EncryptedPassword); err != nil {
+		return errors.New("invalid password")
+	}
+
+	if ok, err := s.verifySecondFactor(userID, req.Code, req.RecoveryCode); err != nil {
+		return err
+	} else if !ok {
+		return errors.New("invalid code")
+	}
+
+	return s.mfaRepo.DisableMFA(userID)
+}
+
+// IssueChallenge returns a short-lived token that lets the user finish a
+// password login with a second factor.
+func (s *MFAService) IssueChallenge(userID uint) (string, error) {
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := &models.MFAChallenge{
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(mfaChallengeExpiry),
+	}
+
+	if err := s.mfaRepo.CreateChallenge(challenge); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// CompleteChallenge checks the TOTP or recovery code for a challenge issued by
+// IssueChallenge and returns the user on success. Failures are audited.
+func (s *MFAService) CompleteChallenge(req *models.MFALoginRequest, client models.ClientInfo) (*models.User, error) {
+	invalidToken := errors.New("invalid or expired MFA token")
+
+	challenge, err := s.mfaRepo.FindChallenge(req.MFAToken)
+	if err != nil || challenge.ExpiresAt.Before(time.Now()) {
+		s.recordLoginFailure(0, "invalid_challenge", client)
+		return nil, invalidToken
+	}
+
+	// Count the attempt before checking it, so that concurrent guesses cannot
+	// all get past the limit.
+	if left, err := s.mfaRepo.UseChallengeAttempt(challenge.ID, mfaChallengeAttempts); err != nil {
+		return nil, err
+	} else if !left {
+		s.recordLoginFailure(challenge.UserID, "too_many_attempts", client)
+		return nil, invalidToken
+	}
+
+	ok, err := s.verifySecondFactor(challenge.UserID, req.Code, req.RecoveryCode)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		s.recordLoginFailure(challenge.UserID, "invalid_code", client)
+		return nil, errors.New("invalid code")
+	}
+
+	if deleted, err := s.mfaRepo.DeleteChallenge(challenge.ID); err != nil {
+		return nil, err
+	} else if !deleted {
+		s.recordLoginFailure(challenge.UserID, "invalid_challenge", client)
+		return nil, invalidToken
+	}
+
+	user, err := s.userRepo.FindByID(challenge.UserID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	return user, nil
+}
+
+func (s *MFAService) recordLoginFailure(userID uint, reason string, client models.ClientInfo) {
+	s.audit.Record(AuditEntry{Type: AuditLoginFailed, UserID: userID, Client: client, Details: map[string]string{"method": "mfa", "reason": reason}})
+}
+
+func (s *MFAService) verifySecondFactor(userID uint, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		secret, err := s.mfaRepo.FindTOTPSecret(userID)
+		if err != nil || secret.ConfirmedAt == nil {
+			return false, errors.New("MFA is not enabled")
+		}
+		if ok, err := s.verifyTOTP(secret, code); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	if recoveryCode != "" {
+		return s.mfaRepo.ConsumeRecoveryCode(userID, normalizeRecoveryCode(recoveryCode))
+	}
+
+	return false, nil
+}
+
+func (s *MFAService) verifyTOTP(secret *models.TOTPSecret, code string) (bool, error) {
+	key, err := base32NoPadding.DecodeString(secret.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	current := time.Now().Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return s.mfaRepo.AdvanceTOTPStep(secret.ID, step)
+		}
+	}
+
+	return false, nil
+}
+
+func (s *MFAService) otpauthURI(user *models.User, secret string) string {
+	issuer := s.cfg.Auth.MFAIssuer
+	if issuer == "" {
+		issuer = defaultMFAIssuer
+	}
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + user.Email,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// totpCode computes the RFC 6238 code for the given time step.
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeHalfChars*2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+	return string(b[:recoveryCodeHalfChars]) + "-" + string(b[recoveryCodeHalfChars:]), nil
+}
+
+// normalizeRecoveryCode ignores case and spaces, which are easy to get wrong
+// when typing a code from paper.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
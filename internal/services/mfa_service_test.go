@@ -0,0 +1,88 @@
+package services
+
+import (
+	"testing"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+	"skyphin-api/pkg/password"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// testArgon2 keeps password hashing fast in tests.
+var testArgon2 = config.PasswordConfig{Argon2MemoryKiB: 64, Argon2Iterations: 1, Argon2Parallelism: 1}
+
+func newTestMFAService(t *testing.T, passwords config.PasswordConfig) (*MFAService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	hasher, err := password.New(passwords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userRepo := repositories.NewUserRepository(db)
+	s := NewMFAService(userRepo, repositories.NewMFARepository(db, newTestHasher(t)), NewPasswordService(userRepo, hasher, nil, config.Config{}), nil, config.Config{})
+	return s, mock
+}
+
+func hashPassword(t *testing.T, cfg config.PasswordConfig, plain string) string {
+	t.Helper()
+
+	hasher, err := password.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := hasher.Hash(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func expectUserWithPassword(mock sqlmock.Sqlmock, userID uint, hash string) {
+	mock.ExpectQuery(`FROM "users" WHERE "users"."id" = \$1`).WithArgs(userID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "encrypted_password", "mfa_enabled"}).AddRow(userID, "jane@example.com", hash, true))
+}
+
+func TestDisableTOTP(t *testing.T) {
+	outdated := testArgon2
+	outdated.Argon2Iterations = 2
+
+	tests := []struct {
+		name     string
+		hash     config.PasswordConfig
+		password string
+		disabled bool
+	}{
+		{"correct password, current hash", testArgon2, "correct horse", true},
+		{"correct password, outdated hash", outdated, "correct horse", true},
+		{"wrong password", testArgon2, "wrong horse", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestMFAService(t, testArgon2)
+
+			expectUserWithPassword(mock, 42, hashPassword(t, tt.hash, "correct horse"))
+			if tt.disabled {
+				mock.ExpectExec(`UPDATE "recovery_codes" SET "used_at"=\$1 WHERE user_id = \$2 AND code_hash = \$3 AND used_at IS NULL`).
+					WithArgs(sqlmock.AnyArg(), 42, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM "totp_secrets" WHERE user_id = \$1`).WithArgs(42).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`DELETE FROM "recovery_codes" WHERE user_id = \$1`).WithArgs(42).WillReturnResult(sqlmock.NewResult(0, 10))
+				mock.ExpectExec(`UPDATE "users" SET "mfa_enabled"=\$1`).WithArgs(false, sqlmock.AnyArg(), 42).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			err := s.DisableTOTP(42, &models.DisableMFARequest{Password: tt.password, RecoveryCode: "abcde-fghij"})
+			if tt.disabled && err != nil {
+				t.Fatal(err)
+			}
+			if !tt.disabled && (err == nil || err.Error() != "invalid password") {
+				t.Fatalf("err = %v, want invalid password", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"database/sql/driver"
+	"testing"
+
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testTokenHashKey = "0123456789abcdef0123456789abcdef"
+
+// newMockDB returns a Postgres gorm.DB backed by sqlmock. Every expectation
+// must be met by the end of the test.
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+func newTestHasher(t *testing.T) *repositories.TokenHasher {
+	t.Helper()
+
+	hasher, err := repositories.NewTokenHasher(testTokenHashKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hasher
+}
+
+// expectAudit expects an audit event of the given type, about userID or
+// about no user when it is 0, and returns a capture of its details.
+func expectAudit(mock sqlmock.Sqlmock, eventType string, userID uint) *capture {
+	var user driver.Value
+	if userID != 0 {
+		user = userID
+	}
+	details := &capture{}
+	mock.ExpectQuery(`INSERT INTO "audit_events"`).
+		WithArgs(eventType, user, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), details, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	return details
+}
+
+// capture is a sqlmock argument that matches anything and keeps the value,
+// so that a later query can return what an earlier one wrote.
+type capture struct {
+	value driver.Value
+}
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func (c *capture) bytes() []byte {
+	b, _ := c.value.([]byte)
+	return b
+}
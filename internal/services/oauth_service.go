@@ -0,0 +1,562 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"gorm.io/gorm"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+	GrantClientCredentials = "client_credentials"
+
+	// ScopeIntrospect lets a client, typically another API holding a
+	// client_credentials token, ask whether access tokens are still active.
+	ScopeIntrospect = "introspect"
+
+	defaultAuthorizationCodeTTL = 5 * time.Minute
+	oauthClientIDBytes          = 16
+	oauthClientSecretBytes      = 32
+)
+
+var (
+	supportedGrantTypes = []string{GrantAuthorizationCode, GrantRefreshToken, GrantClientCredentials}
+	scopePattern        = regexp.MustCompile(`^[A-Za-z0-9_.:/-]+$`)
+	// RFC 7636 section 4.1: 43 to 128 unreserved characters.
+	pkceVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+)
+
+// OAuthError is an error response as defined in RFC 6749, e.g.
+// "invalid_grant".
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+// OAuthService lets other applications sign users in with the authorization
+// code flow and PKCE, and authenticate as themselves with client credentials.
+type OAuthService struct {
+	repo  *repositories.OAuthRepository
+	auth  *AuthService
+	tx    *repositories.Transactor
+	audit *AuditService
+	cfg   config.Config
+}
+
+func NewOAuthService(repo *repositories.OAuthRepository, auth *AuthService, tx *repositories.Transactor, audit *AuditService, cfg config.Config) *OAuthService {
+	return &OAuthService{repo: repo, auth: auth, tx: tx, audit: audit, cfg: cfg}
+}
+
+// CreateClient registers a client. The secret of a confidential client is
+// returned only here.
+func (s *OAuthService) CreateClient(req *models.CreateOAuthClientRequest, actorID uint, client models.ClientInfo) (*models.OAuthClient, string, error) {
+	oauthClient := &models.OAuthClient{
+		Name:         strings.TrimSpace(req.Name),
+		Confidential: req.Confidential,
+		GrantTypes:   []string{},
+		RedirectURIs: []string{},
+		Scopes:       []string{},
+	}
+
+	for _, grantType := range req.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, grantType) {
+			return nil, "", fmt.Errorf("unsupported grant type %s", grantType)
+		}
+		if !slices.Contains(oauthClient.GrantTypes, grantType) {
+			oauthClient.GrantTypes = append(oauthClient.GrantTypes, grantType)
+		}
+	}
+	if len(oauthClient.GrantTypes) == 0 {
+		return nil, "", errors.New("at least one grant type is required")
+	}
+	if slices.Contains(oauthClient.GrantTypes, GrantClientCredentials) && !oauthClient.Confidential {
+		return nil, "", errors.New("only confidential clients can use client_credentials")
+	}
+	if slices.Contains(oauthClient.GrantTypes, GrantRefreshToken) && !slices.Contains(oauthClient.GrantTypes, GrantAuthorizationCode) {
+		return nil, "", errors.New("refresh_token requires authorization_code")
+	}
+
+	for _, redirectURI := range req.RedirectURIs {
+		if err := validateRedirectURI(redirectURI); err != nil {
+			return nil, "", err
+		}
+		if !slices.Contains(oauthClient.RedirectURIs, redirectURI) {
+			oauthClient.RedirectURIs = append(oauthClient.RedirectURIs, redirectURI)
+		}
+	}
+	if slices.Contains(oauthClient.GrantTypes, GrantAuthorizationCode) && len(oauthClient.RedirectURIs) == 0 {
+		return nil, "", errors.New("authorization_code requires a redirect URI")
+	}
+
+	for _, scope := range req.Scopes {
+		if !scopePattern.MatchString(scope) {
+			return nil, "", fmt.Errorf("invalid scope %q", scope)
+		}
+		if !slices.Contains(oauthClient.Scopes, scope) {
+			oauthClient.Scopes = append(oauthClient.Scopes, scope)
+		}
+	}
+
+	clientID, err := randomHex(oauthClientIDBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	oauthClient.ClientID = clientID
+
+	var secret string
+	if oauthClient.Confidential {
+		secret, err = randomHex(oauthClientSecretBytes)
+		if err != nil {
+			return nil, "", err
+		}
+		oauthClient.Secret = secret
+	}
+
+	if err := s.repo.CreateClient(oauthClient); err != nil {
+		return nil, "", err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditOAuthClientCreated, ActorID: actorID, Client: client, Details: map[string]string{"client_id": oauthClient.ClientID}})
+	return oauthClient, secret, nil
+}
+
+func (s *OAuthService) ListClients() ([]models.OAuthClient, error) {
+	return s.repo.FindClients()
+}
+
+// DeleteClient removes the client and revokes every token issued to it.
+func (s *OAuthService) DeleteClient(id uint, actorID uint, client models.ClientInfo) error {
+	oauthClient, err := s.repo.FindClientByID(id)
+	if err != nil {
+		return errors.New("client not found")
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		return s.repo.WithTx(tx).DeleteClient(oauthClient.ID)
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.auth.RevokeClientTokens(oauthClient.ID, 0); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditOAuthClientDeleted, ActorID: actorID, Client: client, Details: map[string]string{"client_id": oauthClient.ClientID}})
+	return nil
+}
+
+// ValidateAuthorization checks an authorization request and fills in the
+// redirect URI if the client has only one. The client is returned as soon as
+// client_id and redirect_uri are known to be good; only then may an error be
+// reported to the client through its redirect URI.
+func (s *OAuthService) ValidateAuthorization(req *models.AuthorizeRequest) (*models.OAuthClient, []string, error) {
+	oauthClient, err := s.repo.FindClientByClientID(req.ClientID)
+	if err != nil {
+		return nil, nil, &OAuthError{Code: "invalid_client", Description: "unknown client"}
+	}
+
+	if req.RedirectURI == "" && len(oauthClient.RedirectURIs) == 1 {
+		req.RedirectURI = oauthClient.RedirectURIs[0]
+	}
+	if !slices.Contains(oauthClient.RedirectURIs, req.RedirectURI) {
+		return nil, nil, &OAuthError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+
+	if req.ResponseType != "code" {
+		return oauthClient, nil, &OAuthError{Code: "unsupported_response_type", Description: "response_type must be code"}
+	}
+	if !slices.Contains(oauthClient.GrantTypes, GrantAuthorizationCode) {
+		return oauthClient, nil, &OAuthError{Code: "unauthorized_client", Description: "client may not use the authorization code grant"}
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return oauthClient, nil, &OAuthError{Code: "invalid_request", Description: "PKCE with code_challenge_method S256 is required"}
+	}
+
+	scopes, err := s.grantableScopes(oauthClient, req.Scope)
+	if err != nil {
+		return oauthClient, nil, err
+	}
+	return oauthClient, scopes, nil
+}
+
+// ConsentDetails describes an authorization request for the consent screen.
+// ConsentRequired is false if the user already granted the client every
+// requested scope.
+func (s *OAuthService) ConsentDetails(userID uint, req *models.AuthorizeRequest) (*models.ConsentDetails, error) {
+	oauthClient, scopes, err := s.ValidateAuthorization(req)
+	if err != nil {
+		return nil, err
+	}
+
+	consent, err := s.repo.FindConsent(userID, oauthClient.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	return &models.ConsentDetails{
+		ClientID:        oauthClient.ClientID,
+		ClientName:      oauthClient.Name,
+		Scopes:          scopes,
+		ConsentRequired: consent == nil || !scopeSubset(strings.Join(scopes, " "), consent.Scope),
+	}, nil
+}
+
+// Authorize applies the user's decision on the consent screen and returns
+// where to send the browser: the client's redirect URI with either an
+// authorization code or an error.
+func (s *OAuthService) Authorize(userID uint, decision *models.AuthorizeDecision, client models.ClientInfo) (string, error) {
+	req := &decision.AuthorizeRequest
+	oauthClient, scopes, err := s.ValidateAuthorization(req)
+	if oauthClient == nil {
+		return "", err
+	}
+	if err != nil {
+		return s.AuthorizationErrorURL(req, err), nil
+	}
+	if !decision.Approved {
+		return s.AuthorizationErrorURL(req, &OAuthError{Code: "access_denied", Description: "the user denied the request"}), nil
+	}
+
+	scope := strings.Join(scopes, " ")
+	if err := s.rememberConsent(userID, oauthClient.ID, scopes); err != nil {
+		return "", err
+	}
+
+	code, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	authorizationCode := &models.OAuthAuthorizationCode{
+		Code:                code,
+		ClientID:            oauthClient.ID,
+		UserID:              userID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		ExpiresAt:           time.Now().Add(s.codeTTL()),
+	}
+	if err := s.repo.CreateCode(authorizationCode); err != nil {
+		return "", err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditOAuthConsentGranted, UserID: userID, Client: client, Details: map[string]string{"client_id": oauthClient.ClientID, "scope": scope}})
+
+	return withQuery(req.RedirectURI, url.Values{"code": {code}, "state": {req.State}}), nil
+}
+
+// AuthorizationErrorURL reports err to the client through the redirect URI
+// of a request whose client and redirect URI have been validated.
+func (s *OAuthService) AuthorizationErrorURL(req *models.AuthorizeRequest, err error) string {
+	params := url.Values{"error": {"server_error"}, "state": {req.State}}
+
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		params.Set("error", oauthErr.Code)
+		params.Set("error_description", oauthErr.Description)
+	}
+	return withQuery(req.RedirectURI, params)
+}
+
+// ConsentScreenURL is the page of the web app where the user signs in, if
+// needed, and approves the request.
+func (s *OAuthService) ConsentScreenURL(req *models.AuthorizeRequest) string {
+	consentURL := s.cfg.OAuth.ConsentURL
+	if consentURL == "" {
+		consentURL = strings.TrimRight(s.cfg.Server.PublicURL, "/") + "/oauth/consent"
+	}
+
+	return withQuery(consentURL, url.Values{
+		"response_type":         {req.ResponseType},
+		"client_id":             {req.ClientID},
+		"redirect_uri":          {req.RedirectURI},
+		"scope":                 {req.Scope},
+		"state":                 {req.State},
+		"code_challenge":        {req.CodeChallenge},
+		"code_challenge_method": {req.CodeChallengeMethod},
+		"nonce":                 {req.Nonce},
+	})
+}
+
+// Token handles the token endpoint. clientID and clientSecret come from HTTP
+// Basic authentication when the client used it, otherwise from the form.
+func (s *OAuthService) Token(req *models.TokenRequest, clientID string, clientSecret string, client models.ClientInfo) (*models.TokenResponse, error) {
+	oauthClient, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(supportedGrantTypes, req.GrantType) {
+		return nil, &OAuthError{Code: "unsupported_grant_type", Description: "unsupported grant_type"}
+	}
+	if !slices.Contains(oauthClient.GrantTypes, req.GrantType) {
+		return nil, &OAuthError{Code: "unauthorized_client", Description: "client may not use this grant type"}
+	}
+
+	switch req.GrantType {
+	case GrantAuthorizationCode:
+		return s.exchangeCode(oauthClient, req, client)
+	case GrantRefreshToken:
+		response, err := s.auth.RefreshClientTokens(req.RefreshToken, oauthClient, req.Scope, client)
+		var oauthErr *OAuthError
+		if err != nil && !errors.As(err, &oauthErr) {
+			return nil, &OAuthError{Code: "invalid_grant", Description: err.Error()}
+		}
+		return response, err
+	default:
+		scopes, err := s.grantableScopes(oauthClient, req.Scope)
+		if err != nil {
+			return nil, err
+		}
+		return s.auth.IssueClientTokens(ClientGrant{Client: oauthClient, Scope: strings.Join(scopes, " ")}, false)
+	}
+}
+
+func (s *OAuthService) exchangeCode(oauthClient *models.OAuthClient, req *models.TokenRequest, client models.ClientInfo) (*models.TokenResponse, error) {
+	invalidGrant := &OAuthError{Code: "invalid_grant", Description: "invalid or expired authorization code"}
+
+	code, err := s.repo.FindCode(req.Code)
+	if err != nil || code.ClientID != oauthClient.ID {
+		return nil, invalidGrant
+	}
+	if code.UsedAt != nil {
+		return nil, s.handleCodeReuse(code)
+	}
+	if code.ExpiresAt.Before(time.Now()) {
+		return nil, invalidGrant
+	}
+	// RFC 6749 section 4.1.3 only requires redirect_uri here if the
+	// authorization request had it, which the code does not tell since a
+	// client's only URI is filled in. So it is checked when it is sent.
+	if req.RedirectURI != "" && req.RedirectURI != code.RedirectURI {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "redirect_uri does not match the authorization request"}
+	}
+	if !verifyPKCE(req.CodeVerifier, code.CodeChallenge) {
+		return nil, &OAuthError{Code: "invalid_grant", Description: "code_verifier does not match the code_challenge"}
+	}
+
+	consumed, err := s.repo.ConsumeCode(code.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, s.handleCodeReuse(code)
+	}
+
+	grant := ClientGrant{UserID: code.UserID, Client: oauthClient, Scope: code.Scope, Nonce: code.Nonce}
+	return s.auth.IssueClientTokens(grant, slices.Contains(oauthClient.GrantTypes, GrantRefreshToken))
+}
+
+// handleCodeReuse revokes the tokens issued for a code that is presented
+// twice, as RFC 6749 section 4.1.2 recommends, since one of the two requests
+// may come from an attacker.
+func (s *OAuthService) handleCodeReuse(code *models.OAuthAuthorizationCode) error {
+	if err := s.auth.RevokeClientTokens(code.ClientID, code.UserID); err != nil {
+		return err
+	}
+	return &OAuthError{Code: "invalid_grant", Description: "authorization code was already used"}
+}
+
+// Introspect reports on an access token issued to an OAuth client, as RFC
+// 7662 describes. Tokens of first-party sessions are reported as inactive
+// like revoked or expired ones.
+func (s *OAuthService) Introspect(token string) (*models.IntrospectionResponse, error) {
+	inactive := &models.IntrospectionResponse{}
+
+	claims, err := s.auth.ParseAccessToken(token)
+	if err != nil {
+		return inactive, nil
+	}
+	clientID, ok := claims["client_id"].(string)
+	if !ok {
+		return inactive, nil
+	}
+
+	active, err := s.auth.IsAccessTokenActive(token)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return inactive, nil
+	}
+
+	response := &models.IntrospectionResponse{Active: true, ClientID: clientID, TokenType: "Bearer"}
+	response.Scope, _ = claims["scope"].(string)
+	response.Subject, _ = claims["sub"].(string)
+	response.Issuer, _ = claims["iss"].(string)
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		response.ExpiresAt = exp.Unix()
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		response.IssuedAt = iat.Unix()
+	}
+	return response, nil
+}
+
+func (s *OAuthService) authenticateClient(clientID string, clientSecret string) (*models.OAuthClient, error) {
+	invalidClient := &OAuthError{Code: "invalid_client", Description: "client authentication failed"}
+
+	oauthClient, err := s.repo.FindClientByClientID(clientID)
+	if err != nil {
+		return nil, invalidClient
+	}
+	if !oauthClient.Confidential {
+		return oauthClient, nil
+	}
+
+	if !s.repo.ClientSecretMatches(oauthClient, clientSecret) {
+		return nil, invalidClient
+	}
+	return oauthClient, nil
+}
+
+// grantableScopes returns the requested scopes, or all of the client's
+// scopes if none were requested. openid is only granted while ID tokens can
+// be signed with an asymmetric key.
+func (s *OAuthService) grantableScopes(oauthClient *models.OAuthClient, scope string) ([]string, error) {
+	openID := s.auth.keys.HasAsymmetricKey()
+
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		scopes := []string{}
+		for _, name := range oauthClient.Scopes {
+			if name != ScopeOpenID || openID {
+				scopes = append(scopes, name)
+			}
+		}
+		return scopes, nil
+	}
+
+	scopes := []string{}
+	for _, name := range requested {
+		if !slices.Contains(oauthClient.Scopes, name) {
+			return nil, &OAuthError{Code: "invalid_scope", Description: fmt.Sprintf("scope %s is not allowed for this client", name)}
+		}
+		if name == ScopeOpenID && !openID {
+			return nil, &OAuthError{Code: "invalid_scope", Description: "openid is not available without an asymmetric signing key"}
+		}
+		if !slices.Contains(scopes, name) {
+			scopes = append(scopes, name)
+		}
+	}
+	return scopes, nil
+}
+
+// rememberConsent adds scopes to what the user has granted the client.
+func (s *OAuthService) rememberConsent(userID uint, clientID uint, scopes []string) error {
+	consent, err := s.repo.FindConsent(userID, clientID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		consent = &models.OAuthConsent{UserID: userID, ClientID: clientID}
+	} else if err != nil {
+		return err
+	}
+
+	granted := strings.Fields(consent.Scope)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	consent.Scope = strings.Join(granted, " ")
+
+	return s.repo.SaveConsent(consent)
+}
+
+func (s *OAuthService) codeTTL() time.Duration {
+	return config.SecondsOr(s.cfg.OAuth.AuthorizationCodeTTLSeconds, defaultAuthorizationCodeTTL)
+}
+
+// validateRedirectURI accepts absolute https URLs, http URLs on the loopback
+// interface for native apps, and private-use schemes such as com.example.app.
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return fmt.Errorf("invalid redirect URI %q", raw)
+	}
+
+	switch u.Scheme {
+	case "https":
+	case "http":
+		if host := u.Hostname(); host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return fmt.Errorf("redirect URI %q must use https", raw)
+		}
+	default:
+		if !strings.Contains(u.Scheme, ".") {
+			return fmt.Errorf("redirect URI %q must use https or a reverse domain scheme", raw)
+		}
+	}
+	return nil
+}
+
+func verifyPKCE(verifier string, challenge string) bool {
+	if !pkceVerifierPattern.MatchString(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// scopeSubset reports whether every scope in the space-separated requested
+// list is in granted.
+func scopeSubset(requested string, granted string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, scope := range strings.Fields(requested) {
+		if !slices.Contains(grantedScopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// withQuery adds params, skipping empty ones, to the query of rawURL.
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testClientSecret = "secret"
+
+func newTestOAuthService(t *testing.T) (*OAuthService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	hasher := newTestHasher(t)
+	cfg := config.Config{Auth: config.AuthConfig{AccessTokenSecret: "access-token-secret", AccessTokenExpiryMinutes: 15}}
+
+	keys, err := NewKeyManager(cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &AuthService{authRepo: repositories.NewAuthRepository(db, hasher), keys: keys, cfg: cfg, tokenCache: newTokenCache(time.Minute)}
+	return NewOAuthService(repositories.NewOAuthRepository(db, hasher), auth, repositories.NewTransactor(db), nil, cfg), mock
+}
+
+// expectClient expects the confidential client with the given grant type and
+// scopes to be looked up.
+func expectClient(t *testing.T, mock sqlmock.Sqlmock, clientID string, grantType string, scopes string) {
+	hasher := newTestHasher(t)
+	mock.ExpectQuery(`FROM "o_auth_clients" WHERE client_id = \$1`).WithArgs(clientID, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "redirect_uris", "grant_types", "scopes", "confidential"}).
+			AddRow(3, clientID, hasher.Hash(testClientSecret), []byte(`["https://app.example.com/callback"]`), []byte(`["`+grantType+`"]`), []byte(scopes), true))
+}
+
+func TestClientCredentialsTokenActsForTheClientAlone(t *testing.T) {
+	s, mock := newTestOAuthService(t)
+
+	expectClient(t, mock, "backend", GrantClientCredentials, `["introspect"]`)
+	mock.ExpectQuery(`INSERT INTO "access_tokens"`).
+		WithArgs(0, nil, 3, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	response, err := s.Token(&models.TokenRequest{GrantType: GrantClientCredentials}, "backend", testClientSecret, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.RefreshToken != "" || response.IDToken != "" {
+		t.Errorf("client_credentials issued a refresh or ID token: %+v", response)
+	}
+
+	claims, err := s.auth.ParseAccessToken(response.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := claims["user_id"]; ok {
+		t.Errorf("token carries user_id %v", claims["user_id"])
+	}
+	if claims["sub"] != "backend" || claims["client_id"] != "backend" || claims["scope"] != ScopeIntrospect {
+		t.Errorf("claims = %v", claims)
+	}
+
+	mock.ExpectQuery(`FROM "access_tokens" WHERE token_hash = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "client_id", "expires_at"}).AddRow(1, 0, 3, time.Now().Add(time.Minute)))
+
+	introspection, err := s.Introspect(response.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !introspection.Active || introspection.ClientID != "backend" || introspection.Subject != "backend" || introspection.Scope != ScopeIntrospect {
+		t.Errorf("introspection = %+v", introspection)
+	}
+}
+
+func TestIntrospectReportsUnknownTokensAsInactive(t *testing.T) {
+	s, _ := newTestOAuthService(t)
+
+	introspection, err := s.Introspect("not a token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if introspection.Active {
+		t.Error("an invalid token is reported as active")
+	}
+}
+
+func TestCreateClientRefusesClientCredentialsForPublicClients(t *testing.T) {
+	s, _ := newTestOAuthService(t)
+
+	_, _, err := s.CreateClient(&models.CreateOAuthClientRequest{Name: "SPA", GrantTypes: []string{GrantClientCredentials}}, 1, models.ClientInfo{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestExchangeCodeChecksRedirectURIOnlyWhenSent(t *testing.T) {
+	tests := []struct {
+		name        string
+		redirectURI string
+		want        string
+	}{
+		{"omitted", "", "code_verifier does not match the code_challenge"},
+		{"same", "https://app.example.com/callback", "code_verifier does not match the code_challenge"},
+		{"different", "https://evil.example.com/callback", "redirect_uri does not match the authorization request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestOAuthService(t)
+
+			expectClient(t, mock, "app", GrantAuthorizationCode, `[]`)
+			mock.ExpectQuery(`FROM "o_auth_authorization_codes" WHERE code_hash = \$1`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "client_id", "user_id", "redirect_uri", "code_challenge", "expires_at"}).
+					AddRow(5, 3, 42, "https://app.example.com/callback", "challenge", time.Now().Add(time.Minute)))
+
+			req := &models.TokenRequest{GrantType: GrantAuthorizationCode, Code: "code", RedirectURI: tt.redirectURI, CodeVerifier: "wrong"}
+			_, err := s.Token(req, "app", testClientSecret, models.ClientInfo{})
+
+			var oauthErr *OAuthError
+			if !errors.As(err, &oauthErr) || oauthErr.Description != tt.want {
+				t.Fatalf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
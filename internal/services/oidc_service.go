@@ -0,0 +1,98 @@
+package services
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// OIDCService adds OpenID Connect on top of the OAuth authorization server:
+// discovery and the userinfo endpoint. ID tokens are issued by AuthService.
+type OIDCService struct {
+	userRepo *repositories.UserRepository
+	keys     *KeyManager
+	cfg      config.Config
+}
+
+func NewOIDCService(userRepo *repositories.UserRepository, keys *KeyManager, cfg config.Config) *OIDCService {
+	return &OIDCService{userRepo: userRepo, keys: keys, cfg: cfg}
+}
+
+// Discovery returns the document served at /.well-known/openid-configuration.
+func (s *OIDCService) Discovery() map[string]any {
+	issuer := oauthIssuer(s.cfg)
+	scopes := []string{ScopeProfile, ScopeEmail}
+	if s.keys.HasAsymmetricKey() {
+		scopes = append([]string{ScopeOpenID}, scopes...)
+	}
+
+	return map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/userinfo",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 supportedGrantTypes,
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": s.keys.SigningAlgorithms(),
+		"scopes_supported":                      scopes,
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "preferred_username", "updated_at", "email", "email_verified"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	}
+}
+
+// UserInfo returns the claims about the user that scope allows.
+func (s *OIDCService) UserInfo(userID uint, scope string) (map[string]any, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return userClaims(user, scope), nil
+}
+
+// userClaims returns the standard claims for user that the scopes in the
+// space-separated scope allow.
+func userClaims(user *models.User, scope string) map[string]any {
+	claims := map[string]any{"sub": strconv.FormatUint(uint64(user.ID), 10)}
+
+	if hasScope(scope, ScopeProfile) {
+		claims["preferred_username"] = user.Username
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if hasScope(scope, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.Verified
+	}
+	return claims
+}
+
+func hasScope(scope string, name string) bool {
+	return slices.Contains(strings.Fields(scope), name)
+}
+
+// oauthIssuer is the base URL of this API as seen by OAuth clients.
+func oauthIssuer(cfg config.Config) string {
+	if cfg.OAuth.Issuer != "" {
+		return strings.TrimRight(cfg.OAuth.Issuer, "/")
+	}
+	return strings.TrimRight(cfg.Server.PublicURL, "/")
+}
+
+// secureCookies reports whether cookies set by this API should be limited to
+// HTTPS.
+func secureCookies(cfg config.Config) bool {
+	return strings.HasPrefix(oauthIssuer(cfg), "https://")
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"gorm.io/gorm"
+)
+
+const invitationExpiry = 7 * 24 * time.Hour
+
+var orgRoleRank = map[string]int{
+	models.OrgRoleMember: 1,
+	models.OrgRoleAdmin:  2,
+	models.OrgRoleOwner:  3,
+}
+
+type OrganizationService struct {
+	userRepo   *repositories.UserRepository
+	orgRepo    *repositories.OrganizationRepository
+	outboxRepo *repositories.OutboxRepository
+	tx         *repositories.Transactor
+}
+
+func NewOrganizationService(userRepo *repositories.UserRepository, orgRepo *repositories.OrganizationRepository, outboxRepo *repositories.OutboxRepository, tx *repositories.Transactor) *OrganizationService {
+	return &OrganizationService{userRepo: userRepo, orgRepo: orgRepo, outboxRepo: outboxRepo, tx: tx}
+}
+
+// CreateOrganization creates an organization owned by the user.
+func (s *OrganizationService) CreateOrganization(userID uint, req *models.CreateOrganizationRequest) (*models.Organization, error) {
+	org := &models.Organization{Name: strings.TrimSpace(req.Name)}
+	if org.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	err := s.tx.Transaction(func(tx *gorm.DB) error {
+		orgRepo := s.orgRepo.WithTx(tx)
+		if err := orgRepo.Create(org); err != nil {
+			return err
+		}
+		return orgRepo.CreateMembership(&models.Membership{OrganizationID: org.ID, UserID: userID, Role: models.OrgRoleOwner})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return org, nil
+}
+
+func (s *OrganizationService) ListMemberships(userID uint) ([]models.Membership, error) {
+	return s.orgRepo.FindMembershipsByUserID(userID)
+}
+
+func (s *OrganizationService) ListMembers(orgID uint, actorID uint) ([]models.Membership, error) {
+	if _, err := s.requireRole(orgID, actorID, models.OrgRoleMember); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.FindMembers(orgID)
+}
+
+// UpdateMemberRole changes a member's role. Only owners can grant or revoke
+// ownership, and the last owner cannot be demoted.
+func (s *OrganizationService) UpdateMemberRole(orgID uint, actorID uint, userID uint, role string) error {
+	actor, err := s.requireRole(orgID, actorID, models.OrgRoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	member, err := s.orgRepo.FindMembership(orgID, userID)
+	if err != nil {
+		return errors.New("member not found")
+	}
+
+	if (role == models.OrgRoleOwner || member.Role == models.OrgRoleOwner) && actor.Role != models.OrgRoleOwner {
+		return errors.New("only owners can change ownership")
+	}
+	if member.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+		if err := s.ensureAnotherOwner(orgID); err != nil {
+			return err
+		}
+	}
+
+	return s.orgRepo.UpdateMemberRole(orgID, userID, role)
+}
+
+// RemoveMember removes a member. Members may remove themselves; removing
+// others requires admin, and removing an owner requires owner.
+func (s *OrganizationService) RemoveMember(orgID uint, actorID uint, userID uint) error {
+	required := models.OrgRoleAdmin
+	if actorID == userID {
+		required = models.OrgRoleMember
+	}
+	actor, err := s.requireRole(orgID, actorID, required)
+	if err != nil {
+		return err
+	}
+
+	member, err := s.orgRepo.FindMembership(orgID, userID)
+	if err != nil {
+		return errors.New("member not found")
+	}
+
+	if member.Role == models.OrgRoleOwner {
+		if actor.Role != models.OrgRoleOwner {
+			return errors.New("only owners can remove owners")
+		}
+		if err := s.ensureAnotherOwner(orgID); err != nil {
+			return err
+		}
+	}
+
+	return s.orgRepo.RemoveMember(orgID, userID)
+}
+
+// InviteMember stores an invitation and queues the invitation email in the
+// same transaction.
+func (s *OrganizationService) InviteMember(orgID uint, actorID uint, req *models.InviteMemberRequest) (*models.Invitation, error) {
+	actor, err := s.requireRole(orgID, actorID, models.OrgRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if req.Role == models.OrgRoleOwner && actor.Role != models.OrgRoleOwner {
+		return nil, errors.New("only owners can invite owners")
+	}
+
+	org, err := s.orgRepo.FindByID(orgID)
+	if err != nil {
+		return nil, errors.New("organization not found")
+	}
+
+	if user, err := s.userRepo.FindByEmail(req.Email); err == nil {
+		if _, err := s.orgRepo.FindMembership(orgID, user.ID); err == nil {
+			return nil, errors.New("user is already a member")
+		}
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	invitation := &models.Invitation{
+		OrganizationID: orgID,
+		Email:          req.Email,
+		Role:           req.Role,
+		Token:          token,
+		Status:         models.InvitationPending,
+		InvitedByID:    actorID,
+		ExpiresAt:      time.Now().Add(invitationExpiry),
+	}
+
+	msg, err := newOutboxMessage(OutboxInvitationEmail, invitationEmailPayload{To: req.Email, Token: token, Organization: org.Name})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		if err := s.orgRepo.WithTx(tx).CreateInvitation(invitation); err != nil {
+			return err
+		}
+		return s.outboxRepo.WithTx(tx).Create(msg)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return invitation, nil
+}
+
+func (s *OrganizationService) ListInvitations(orgID uint, actorID uint) ([]models.Invitation, error) {
+	if _, err := s.requireRole(orgID, actorID, models.OrgRoleAdmin); err != nil {
+		return nil, err
+	}
+	return s.orgRepo.FindInvitations(orgID)
+}
+
+func (s *OrganizationService) RevokeInvitation(orgID uint, actorID uint, invitationID uint) error {
+	if _, err := s.requireRole(orgID, actorID, models.OrgRoleAdmin); err != nil {
+		return err
+	}
+	if err := s.orgRepo.DeleteInvitation(orgID, invitationID); err != nil {
+		return errors.New("invitation not found")
+	}
+	return nil
+}
+
+// ListUserInvitations returns pending invitations addressed to the user.
+func (s *OrganizationService) ListUserInvitations(userID uint) ([]models.Invitation, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	return s.orgRepo.FindPendingInvitationsByEmail(user.Email)
+}
+
+// AcceptInvitation adds the user to the organization. The invitation must be
+// addressed to the user's verified email.
+func (s *OrganizationService) AcceptInvitation(userID uint, token string) (*models.Membership, error) {
+	invitation, user, err := s.findInvitationForUser(userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Verified {
+		return nil, errors.New("account not verified")
+	}
+
+	membership := &models.Membership{OrganizationID: invitation.OrganizationID, UserID: userID, Role: invitation.Role}
+	err = s.tx.Transaction(func(tx *gorm.DB) error {
+		orgRepo := s.orgRepo.WithTx(tx)
+		if _, err := orgRepo.FindMembership(invitation.OrganizationID, userID); err == nil {
+			return errors.New("already a member")
+		}
+		if err := orgRepo.CreateMembership(membership); err != nil {
+			return err
+		}
+		return orgRepo.UpdateInvitationStatus(invitation.OrganizationID, invitation.ID, models.InvitationAccepted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	membership.Organization = invitation.Organization
+	return membership, nil
+}
+
+func (s *OrganizationService) DeclineInvitation(userID uint, token string) error {
+	invitation, _, err := s.findInvitationForUser(userID, token)
+	if err != nil {
+		return err
+	}
+	return s.orgRepo.UpdateInvitationStatus(invitation.OrganizationID, invitation.ID, models.InvitationDeclined)
+}
+
+// SetActiveOrganization selects the organization carried in the user's next
+// access tokens. A nil orgID clears it.
+func (s *OrganizationService) SetActiveOrganization(userID uint, orgID *uint) error {
+	if orgID != nil {
+		if _, err := s.requireRole(*orgID, userID, models.OrgRoleMember); err != nil {
+			return err
+		}
+	}
+	return s.userRepo.SetActiveOrganization(userID, orgID)
+}
+
+// ActiveMembership returns the membership of the user's active organization,
+// or nil if none is selected or the user has since left it.
+func (s *OrganizationService) ActiveMembership(userID uint) (*models.Membership, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.ActiveOrganizationID == nil {
+		return nil, nil
+	}
+
+	membership, err := s.orgRepo.FindMembership(*user.ActiveOrganizationID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return membership, err
+}
+
+func (s *OrganizationService) findInvitationForUser(userID uint, token string) (*models.Invitation, *models.User, error) {
+	invitation, err := s.orgRepo.FindInvitationByToken(token)
+	if err != nil || invitation.Status != models.InvitationPending || invitation.ExpiresAt.Before(time.Now()) {
+		return nil, nil, errors.New("invalid or expired invitation")
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, nil, errors.New("user not found")
+	}
+	if !strings.EqualFold(user.Email, invitation.Email) {
+		return nil, nil, errors.New("invitation was sent to a different email address")
+	}
+
+	return invitation, user, nil
+}
+
+func (s *OrganizationService) requireRole(orgID uint, userID uint, role string) (*models.Membership, error) {
+	membership, err := s.orgRepo.FindMembership(orgID, userID)
+	if err != nil {
+		return nil, errors.New("organization not found")
+	}
+	if orgRoleRank[membership.Role] < orgRoleRank[role] {
+		return nil, errors.New("insufficient organization role")
+	}
+	return membership, nil
+}
+
+func (s *OrganizationService) ensureAnotherOwner(orgID uint) error {
+	owners, err := s.orgRepo.CountMembersWithRole(orgID, models.OrgRoleOwner)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return errors.New("an organization needs at least one owner")
+	}
+	return nil
+}
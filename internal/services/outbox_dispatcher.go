@@ -0,0 +1,266 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+)
+
+const (
+	OutboxVerificationEmail  = "email.verification"
+	OutboxPasswordResetEmail = "email.password_reset"
+	OutboxInvitationEmail    = "email.organization_invitation"
+	OutboxAccountLockedEmail = "email.account_locked"
+	OutboxMagicLinkEmail     = "email.magic_link"
+	OutboxUserRegistered     = "event.user_registered"
+	OutboxUserVerified       = "event.user_verified"
+	OutboxPasswordReset      = "event.password_reset"
+	OutboxAccountLocked      = "event.account_locked"
+	OutboxAccountUnlocked    = "event.account_unlocked"
+
+	defaultOutboxPollInterval = 5 * time.Second
+	defaultOutboxBatchSize    = 20
+	defaultOutboxMaxAttempts  = 8
+	defaultOutboxBackoffBase  = 10 * time.Second
+	defaultOutboxBackoffMax   = time.Hour
+	outboxLease               = 5 * time.Minute
+	webhookTimeout            = 10 * time.Second
+)
+
+type emailPayload struct {
+	To    string `json:"to"`
+	Token string `json:"token"`
+}
+
+type magicLinkEmailPayload struct {
+	To    string `json:"to"`
+	Token string `json:"token"`
+	Code  string `json:"code"`
+}
+
+type invitationEmailPayload struct {
+	To           string `json:"to"`
+	Token        string `json:"token"`
+	Organization string `json:"organization"`
+}
+
+type userEventPayload struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// OutboxHandler delivers a single message. Returning an error schedules a
+// retry.
+type OutboxHandler func(msg *models.OutboxMessage) error
+
+// OutboxDispatcher delivers outbox messages in the background with
+// exponential backoff. Messages that keep failing are marked dead and can be
+// requeued by an admin.
+type OutboxDispatcher struct {
+	repo         *repositories.OutboxRepository
+	handlers     map[string]OutboxHandler
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+}
+
+func NewOutboxDispatcher(repo *repositories.OutboxRepository, emailService *EmailService, cfg config.Config) *OutboxDispatcher {
+	d := &OutboxDispatcher{
+		repo:         repo,
+		handlers:     make(map[string]OutboxHandler),
+		pollInterval: config.SecondsOr(cfg.Outbox.PollIntervalSeconds, defaultOutboxPollInterval),
+		batchSize:    config.IntOr(cfg.Outbox.BatchSize, defaultOutboxBatchSize),
+		maxAttempts:  config.IntOr(cfg.Outbox.MaxAttempts, defaultOutboxMaxAttempts),
+		backoffBase:  config.SecondsOr(cfg.Outbox.BackoffBaseSeconds, defaultOutboxBackoffBase),
+		backoffMax:   config.SecondsOr(cfg.Outbox.BackoffMaxSeconds, defaultOutboxBackoffMax),
+	}
+
+	d.Handle(OutboxVerificationEmail, func(msg *models.OutboxMessage) error {
+		var payload emailPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		return emailService.SendVerificationEmail(payload.To, payload.Token)
+	})
+	d.Handle(OutboxPasswordResetEmail, func(msg *models.OutboxMessage) error {
+		var payload emailPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		return emailService.SendPasswordResetEmail(payload.To, payload.Token)
+	})
+	d.Handle(OutboxAccountLockedEmail, func(msg *models.OutboxMessage) error {
+		var payload emailPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		return emailService.SendAccountLockedEmail(payload.To, payload.Token)
+	})
+	d.Handle(OutboxMagicLinkEmail, func(msg *models.OutboxMessage) error {
+		var payload magicLinkEmailPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		return emailService.SendMagicLinkEmail(payload.To, payload.Token, payload.Code)
+	})
+	d.Handle(OutboxInvitationEmail, func(msg *models.OutboxMessage) error {
+		var payload invitationEmailPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			return err
+		}
+		return emailService.SendOrganizationInvitationEmail(payload.To, payload.Token, payload.Organization)
+	})
+
+	events := newEventHandler(cfg.Outbox.EventsWebhookURL)
+	for _, kind := range []string{OutboxUserRegistered, OutboxUserVerified, OutboxPasswordReset, OutboxAccountLocked, OutboxAccountUnlocked} {
+		d.Handle(kind, events)
+	}
+
+	return d
+}
+
+func (d *OutboxDispatcher) Handle(kind string, handler OutboxHandler) {
+	d.handlers[kind] = handler
+}
+
+// Run polls for due messages until ctx is cancelled.
+func (d *OutboxDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.dispatchBatch(); err != nil {
+			log.Printf("Failed to dispatch outbox: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListMessages returns messages in the given status, newest first.
+func (d *OutboxDispatcher) ListMessages(status string, limit int, offset int) ([]models.OutboxMessage, error) {
+	return d.repo.FindByStatus(status, limit, offset)
+}
+
+// Requeue gives a dead message a fresh set of attempts.
+func (d *OutboxDispatcher) Requeue(id uint) error {
+	return d.repo.Requeue(id)
+}
+
+func (d *OutboxDispatcher) dispatchBatch() error {
+	messages, err := d.repo.ClaimDue(d.batchSize, outboxLease)
+	if err != nil {
+		return err
+	}
+
+	for i := range messages {
+		msg := &messages[i]
+		if err := d.deliver(msg); err != nil {
+			if err := d.fail(msg, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.repo.MarkDelivered(msg.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *OutboxDispatcher) deliver(msg *models.OutboxMessage) (err error) {
+	handler, ok := d.handlers[msg.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for %q", msg.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	return handler(msg)
+}
+
+func (d *OutboxDispatcher) fail(msg *models.OutboxMessage, cause error) error {
+	if msg.Attempts >= d.maxAttempts {
+		log.Printf("Outbox message %d (%s) is dead after %d attempts: %v", msg.ID, msg.Kind, msg.Attempts, cause)
+		return d.repo.MarkDead(msg.ID, cause.Error())
+	}
+	return d.repo.MarkFailed(msg.ID, cause.Error(), time.Now().Add(d.backoff(msg.Attempts)))
+}
+
+// backoff doubles the delay with every attempt and adds up to 20% jitter.
+func (d *OutboxDispatcher) backoff(attempts int) time.Duration {
+	delay := d.backoffBase
+	for i := 1; i < attempts && delay < d.backoffMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, d.backoffMax)
+	return delay + rand.N(delay/5+1)
+}
+
+func newEventHandler(webhookURL string) OutboxHandler {
+	if webhookURL == "" {
+		return func(msg *models.OutboxMessage) error {
+			log.Printf("Event %s: %s", msg.Kind, msg.Payload)
+			return nil
+		}
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	return func(msg *models.OutboxMessage) error {
+		body, err := json.Marshal(map[string]any{
+			"id":         msg.ID,
+			"type":       strings.TrimPrefix(msg.Kind, "event."),
+			"data":       msg.Payload,
+			"created_at": msg.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook responded with %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func newOutboxMessage(kind string, payload any) (*models.OutboxMessage, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.OutboxMessage{
+		Kind:          kind,
+		Payload:       data,
+		Status:        models.OutboxStatusPending,
+		NextAttemptAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+	"skyphin-api/pkg/password"
+
+	"gorm.io/gorm"
+)
+
+const defaultPasswordHistorySize = 5
+
+// PasswordService hashes passwords and enforces the password policy,
+// including the ban on reusing recent passwords.
+type PasswordService struct {
+	userRepo    *repositories.UserRepository
+	hasher      *password.Hasher
+	policy      *password.Policy
+	historySize int
+}
+
+func NewPasswordService(userRepo *repositories.UserRepository, hasher *password.Hasher, policy *password.Policy, cfg config.Config) *PasswordService {
+	return &PasswordService{
+		userRepo:    userRepo,
+		hasher:      hasher,
+		policy:      policy,
+		historySize: config.IntOr(cfg.Password.HistorySize, defaultPasswordHistorySize),
+	}
+}
+
+func (s *PasswordService) Hash(plain string) (string, error) {
+	return s.hasher.Hash(plain)
+}
+
+func (s *PasswordService) Verify(plain string, encrypted string) (bool, error) {
+	// Users created through a federated login have no password, nor do
+	// users whose password an administrator reset.
+	if encrypted == "" {
+		return false, password.ErrMismatch
+	}
+	return s.hasher.Verify(plain, encrypted)
+}
+
+// Validate returns a *password.PolicyError if plain breaks the policy or, for
+// a saved user, matches one of their recent passwords.
+func (s *PasswordService) Validate(user *models.User, plain string) error {
+	err := s.policy.Check(plain, user.Username, user.Email)
+
+	var policyErr *password.PolicyError
+	if err != nil && !errors.As(err, &policyErr) {
+		return err
+	}
+	if user.ID == 0 {
+		return err
+	}
+
+	reused, historyErr := s.reused(user.ID, plain)
+	if historyErr != nil {
+		return historyErr
+	}
+	if reused {
+		if policyErr == nil {
+			policyErr = &password.PolicyError{}
+		}
+		policyErr.Add("reused", "must not match one of your recent passwords")
+	}
+
+	if policyErr != nil {
+		return policyErr
+	}
+	return nil
+}
+
+// Remember adds the hash to the user's password history inside tx and drops
+// entries that are no longer checked.
+func (s *PasswordService) Remember(tx *gorm.DB, userID uint, encrypted string) error {
+	userRepo := s.userRepo.WithTx(tx)
+	if err := userRepo.CreatePasswordHistory(&models.PasswordHistory{UserID: userID, EncryptedPassword: encrypted}); err != nil {
+		return err
+	}
+	return userRepo.PrunePasswordHistory(userID, s.historySize)
+}
+
+func (s *PasswordService) reused(userID uint, plain string) (bool, error) {
+	entries, err := s.userRepo.FindPasswordHistory(userID, s.historySize)
+	if err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		if _, err := s.hasher.Verify(plain, entry.EncryptedPassword); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"slices"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AdminRole = "admin"
+
+	PermissionUsersRead         = "users:read"
+	PermissionUsersWrite        = "users:write"
+	PermissionRolesRead         = "roles:read"
+	PermissionRolesWrite        = "roles:write"
+	PermissionOutboxRead        = "outbox:read"
+	PermissionOutboxWrite       = "outbox:write"
+	PermissionAuditRead         = "audit:read"
+	PermissionOAuthClientsRead  = "oauth_clients:read"
+	PermissionOAuthClientsWrite = "oauth_clients:write"
+)
+
+// builtinPermissions are always present and granted to the admin role.
+var builtinPermissions = []string{
+	PermissionUsersRead,
+	PermissionUsersWrite,
+	PermissionRolesRead,
+	PermissionRolesWrite,
+	PermissionOutboxRead,
+	PermissionOutboxWrite,
+	PermissionAuditRead,
+	PermissionOAuthClientsRead,
+	PermissionOAuthClientsWrite,
+}
+
+var (
+	roleNamePattern       = regexp.MustCompile(`^[a-z0-9_-]+$`)
+	permissionNamePattern = regexp.MustCompile(`^[a-z0-9_-]+:[a-z0-9_-]+$`)
+)
+
+type RBACService struct {
+	userRepo *repositories.UserRepository
+	rbacRepo *repositories.RBACRepository
+	audit    *AuditService
+	cfg      config.Config
+}
+
+func NewRBACService(userRepo *repositories.UserRepository, rbacRepo *repositories.RBACRepository, audit *AuditService, cfg config.Config) *RBACService {
+	return &RBACService{userRepo: userRepo, rbacRepo: rbacRepo, audit: audit, cfg: cfg}
+}
+
+// Seed creates the built-in permissions and the admin role, and assigns the
+// admin role to the users listed in ADMIN_USER_IDS.
+func (s *RBACService) Seed() error {
+	permissions, err := s.rbacRepo.EnsurePermissions(builtinPermissions)
+	if err != nil {
+		return err
+	}
+
+	role, err := s.rbacRepo.FindRoleByName(AdminRole)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		role = &models.Role{Name: AdminRole, Description: "Full administrative access"}
+	} else if err != nil {
+		return err
+	}
+	for _, permission := range permissions {
+		if !slices.ContainsFunc(role.Permissions, func(p models.Permission) bool { return p.ID == permission.ID }) {
+			role.Permissions = append(role.Permissions, permission)
+		}
+	}
+	if err := s.rbacRepo.SaveRole(role); err != nil {
+		return err
+	}
+
+	for _, userID := range s.cfg.Auth.AdminUserIDs {
+		if _, err := s.userRepo.FindByID(userID); err != nil {
+			continue // The user may not have registered yet.
+		}
+		if err := s.rbacRepo.AssignRole(userID, role.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *RBACService) ListRoles() ([]models.Role, error) {
+	return s.rbacRepo.FindRoles()
+}
+
+func (s *RBACService) ListPermissions() ([]models.Permission, error) {
+	return s.rbacRepo.FindPermissions()
+}
+
+func (s *RBACService) CreateRole(req *models.RoleRequest, actorID uint, client models.ClientInfo) (*models.Role, error) {
+	if _, err := s.rbacRepo.FindRoleByName(req.Name); err == nil {
+		return nil, errors.New("role already exists")
+	}
+
+	role := &models.Role{}
+	if err := s.applyRoleRequest(role, req); err != nil {
+		return nil, err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditRoleCreated, ActorID: actorID, Client: client, Details: roleDetails(role)})
+	return role, nil
+}
+
+func (s *RBACService) UpdateRole(id uint, req *models.RoleRequest, actorID uint, client models.ClientInfo) (*models.Role, error) {
+	role, err := s.rbacRepo.FindRoleByID(id)
+	if err != nil {
+		return nil, errors.New("role not found")
+	}
+	if role.Name == AdminRole {
+		if req.Name != AdminRole {
+			return nil, errors.New("the admin role cannot be renamed")
+		}
+		// Otherwise a roles:write holder could lock every admin out of
+		// what the role grants, or take roles:write from the others.
+		for _, permission := range role.Permissions {
+			if !slices.Contains(req.Permissions, permission.Name) {
+				return nil, errors.New("permissions cannot be removed from the admin role")
+			}
+		}
+	}
+	if req.Name != role.Name {
+		if _, err := s.rbacRepo.FindRoleByName(req.Name); err == nil {
+			return nil, errors.New("role already exists")
+		}
+	}
+
+	previous := role.Name
+	if err := s.applyRoleRequest(role, req); err != nil {
+		return nil, err
+	}
+
+	details := roleDetails(role)
+	if previous != role.Name {
+		details["previous_name"] = previous
+	}
+	s.audit.Record(AuditEntry{Type: AuditRoleUpdated, ActorID: actorID, Client: client, Details: details})
+	return role, nil
+}
+
+func (s *RBACService) DeleteRole(id uint, actorID uint, client models.ClientInfo) error {
+	role, err := s.rbacRepo.FindRoleByID(id)
+	if err != nil {
+		return errors.New("role not found")
+	}
+	if role.Name == AdminRole {
+		return errors.New("the admin role cannot be deleted")
+	}
+
+	if err := s.rbacRepo.DeleteRole(id); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditRoleDeleted, ActorID: actorID, Client: client, Details: map[string]any{"role": role.Name}})
+	return nil
+}
+
+func (s *RBACService) UserRoles(userID uint) ([]models.Role, error) {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return nil, errors.New("user not found")
+	}
+	return s.rbacRepo.FindRolesByUserID(userID)
+}
+
+func (s *RBACService) AssignRole(userID uint, roleName string, actorID uint, client models.ClientInfo) error {
+	if _, err := s.userRepo.FindByID(userID); err != nil {
+		return errors.New("user not found")
+	}
+
+	role, err := s.rbacRepo.FindRoleByName(roleName)
+	if err != nil {
+		return errors.New("role not found")
+	}
+
+	if err := s.rbacRepo.AssignRole(userID, role.ID); err != nil {
+		return err
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditRoleAssigned, UserID: userID, ActorID: actorID, Client: client, Details: map[string]string{"role": role.Name}})
+	return nil
+}
+
+func (s *RBACService) RemoveRole(userID uint, roleID uint, actorID uint, client models.ClientInfo) error {
+	role, err := s.rbacRepo.FindRoleByID(roleID)
+	if err != nil {
+		return errors.New("role assignment not found")
+	}
+
+	if err := s.rbacRepo.RemoveRole(userID, roleID); err != nil {
+		return errors.New("role assignment not found")
+	}
+
+	s.audit.Record(AuditEntry{Type: AuditRoleRemoved, UserID: userID, ActorID: actorID, Client: client, Details: map[string]string{"role": role.Name}})
+	return nil
+}
+
+// ClaimsForUser returns the role and permission names embedded in the user's
+// access tokens.
+func (s *RBACService) ClaimsForUser(userID uint) ([]string, []string, error) {
+	roles, err := s.rbacRepo.FindRolesByUserID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roleNames := make([]string, 0, len(roles))
+	permissionNames := []string{}
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Name)
+		for _, permission := range role.Permissions {
+			if !slices.Contains(permissionNames, permission.Name) {
+				permissionNames = append(permissionNames, permission.Name)
+			}
+		}
+	}
+	slices.Sort(permissionNames)
+
+	return roleNames, permissionNames, nil
+}
+
+// roleDetails describes a role in audit events.
+func roleDetails(role *models.Role) map[string]any {
+	permissions := make([]string, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		permissions = append(permissions, permission.Name)
+	}
+	return map[string]any{"role": role.Name, "permissions": permissions}
+}
+
+func (s *RBACService) applyRoleRequest(role *models.Role, req *models.RoleRequest) error {
+	if !roleNamePattern.MatchString(req.Name) {
+		return errors.New("role names may only contain lowercase letters, digits, '-' and '_'")
+	}
+	for _, name := range req.Permissions {
+		if !permissionNamePattern.MatchString(name) {
+			return errors.New("invalid permission name " + name + ", expected resource:action")
+		}
+	}
+
+	permissions, err := s.rbacRepo.EnsurePermissions(req.Permissions)
+	if err != nil {
+		return err
+	}
+
+	role.Name = req.Name
+	role.Description = req.Description
+	role.Permissions = permissions
+
+	return s.rbacRepo.SaveRole(role)
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUpdateRoleKeepsAdminPermissions(t *testing.T) {
+	db, mock := newMockDB(t)
+	s := &RBACService{rbacRepo: repositories.NewRBACRepository(db)}
+
+	mock.ExpectQuery(`FROM "roles" WHERE "roles"."id" = \$1`).WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, AdminRole))
+	mock.ExpectQuery(`FROM "role_permissions" WHERE "role_permissions"."role_id" = \$1`).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role_id", "permission_id"}).AddRow(1, 1).AddRow(1, 2))
+	mock.ExpectQuery(`FROM "permissions" WHERE "permissions"."id" IN \(\$1,\$2\)`).WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(1, PermissionRolesRead).AddRow(2, PermissionRolesWrite))
+
+	_, err := s.UpdateRole(1, &models.RoleRequest{Name: AdminRole, Permissions: []string{PermissionRolesRead}}, 1, models.ClientInfo{})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultTokenCacheTTL = 30 * time.Second
+
+// tokenCache remembers whether an access token is still present in the
+// access_tokens table so the middleware does not query Postgres on every
+// request. Revocations made by another instance become visible after ttl:
+// there is no shared invalidation, so ttl bounds how long a revoked token
+// keeps working on the other instances.
+type tokenCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	entries   map[string]tokenCacheEntry
+	lastSweep time.Time
+}
+
+type tokenCacheEntry struct {
+	userID    uint
+	sessionID uint
+	active    bool
+	expiresAt time.Time
+}
+
+func newTokenCache(ttl time.Duration) *tokenCache {
+	if ttl <= 0 {
+		ttl = defaultTokenCacheTTL
+	}
+	return &tokenCache{ttl: ttl, entries: make(map[string]tokenCacheEntry)}
+}
+
+func (c *tokenCache) get(token string) (active bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[token]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(c.entries, token)
+		return false, false
+	}
+	return entry.active, true
+}
+
+func (c *tokenCache) set(token string, userID uint, sessionID uint, active bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) > c.ttl { // Keep the map bounded by dropping stale entries.
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.entries[token] = tokenCacheEntry{userID: userID, sessionID: sessionID, active: active, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *tokenCache) revoke(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[token]
+	c.entries[token] = tokenCacheEntry{userID: entry.userID, sessionID: entry.sessionID, active: false, expiresAt: time.Now().Add(c.ttl)}
+}
+
+func (c *tokenCache) revokeUser(userID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.userID == userID {
+			entry.active = false
+			c.entries[key] = entry
+		}
+	}
+}
+
+func (c *tokenCache) revokeSession(sessionID uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.sessionID == sessionID {
+			entry.active = false
+			c.entries[key] = entry
+		}
+	}
+}
+
+// clear forgets every entry, so that tokens are checked against the database
+// again.
+func (c *tokenCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+}
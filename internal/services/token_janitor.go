@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	defaultJanitorInterval  = 5 * time.Minute
+	defaultJanitorBatchSize = 1000
+
+	// deliveredOutboxRetention keeps delivered messages around for a day to
+	// help debug deliveries.
+	deliveredOutboxRetention = 24 * time.Hour
+
+	// janitorLockID keys the advisory lock that elects the one instance
+	// purging at a time.
+	janitorLockID int64 = 0x6a616e69746f72
+)
+
+var ErrJanitorBusy = errors.New("another instance is purging expired tokens")
+
+const expiredBefore = "expires_at < ?"
+
+// purgeable are the tables whose rows become useless: where matches them
+// given the time the purge started, less retain.
+var purgeable = []struct {
+	name   string
+	model  any
+	where  string
+	retain time.Duration
+}{
+	{"verification_tokens", &models.VerificationToken{}, expiredBefore, 0},
+	{"reset_tokens", &models.ResetToken{}, expiredBefore, 0},
+	{"access_tokens", &models.AccessToken{}, expiredBefore, 0},
+	{"refresh_tokens", &models.RefreshToken{}, expiredBefore, 0},
+	{"magic_link_tokens", &models.MagicLinkToken{}, expiredBefore, 0},
+	{"unlock_tokens", &models.UnlockToken{}, expiredBefore, 0},
+	{"mfa_challenges", &models.MFAChallenge{}, expiredBefore, 0},
+	{"web_authn_sessions", &models.WebAuthnSession{}, expiredBefore, 0},
+	{"o_auth_authorization_codes", &models.OAuthAuthorizationCode{}, expiredBefore, 0},
+	{"federated_logins", &models.FederatedLogin{}, expiredBefore, 0},
+	// LEAST ignores NULL, so a session goes when it is revoked or expires,
+	// whichever is first.
+	{"sessions", &models.Session{}, "LEAST(revoked_at, expires_at) < ?", 0},
+	{"outbox_messages", &models.OutboxMessage{}, "status = '" + models.OutboxStatusDelivered + "' AND delivered_at < ?", deliveredOutboxRetention},
+}
+
+var (
+	janitorDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "skyphin_janitor_deleted_tokens_total",
+		Help: "Expired tokens deleted by the janitor.",
+	}, []string{"table"})
+	janitorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "skyphin_janitor_runs_total",
+		Help: "Janitor runs by result: success, error, or skipped when another instance held the lock.",
+	}, []string{"result"})
+	janitorDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "skyphin_janitor_run_duration_seconds",
+		Help:    "Duration of janitor runs that held the lock.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+	})
+	janitorLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "skyphin_janitor_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful janitor run.",
+	})
+)
+
+// TokenJanitor periodically deletes expired tokens, challenges and login
+// ceremonies, ended sessions and delivered outbox messages. Every instance
+// runs one; an advisory lock makes sure only one of them purges at a time.
+type TokenJanitor struct {
+	authRepo  *repositories.AuthRepository
+	tx        *repositories.Transactor
+	interval  time.Duration
+	batchSize int
+}
+
+func NewTokenJanitor(authRepo *repositories.AuthRepository, tx *repositories.Transactor, cfg config.Config) *TokenJanitor {
+	return &TokenJanitor{
+		authRepo:  authRepo,
+		tx:        tx,
+		interval:  config.SecondsOr(cfg.Janitor.IntervalSeconds, defaultJanitorInterval),
+		batchSize: config.IntOr(cfg.Janitor.BatchSize, defaultJanitorBatchSize),
+	}
+}
+
+// Run purges every interval until ctx is cancelled. A purge in progress
+// stops after its current batch.
+func (j *TokenJanitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := j.Purge(ctx)
+		if err != nil && !errors.Is(err, ErrJanitorBusy) && !errors.Is(err, context.Canceled) {
+			log.Printf("Failed to purge expired tokens: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes expired tokens in batches and returns how many were deleted
+// from each table. It returns ErrJanitorBusy if another instance is purging.
+func (j *TokenJanitor) Purge(ctx context.Context) (map[string]int64, error) {
+	start := time.Now()
+	purged := make(map[string]int64, len(purgeable))
+
+	acquired, err := j.tx.WithLock(janitorLockID, func() error {
+		for _, table := range purgeable {
+			for {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+
+				deleted, err := j.authRepo.DeleteBefore(table.model, table.where, start.Add(-table.retain), j.batchSize)
+				if err != nil {
+					return err
+				}
+
+				purged[table.name] += deleted
+				janitorDeleted.WithLabelValues(table.name).Add(float64(deleted))
+				if deleted < int64(j.batchSize) {
+					break
+				}
+			}
+		}
+		return nil
+	})
+
+	if acquired {
+		janitorDuration.Observe(time.Since(start).Seconds())
+	}
+
+	switch {
+	case err != nil:
+		janitorRuns.WithLabelValues("error").Inc()
+		return purged, err
+	case !acquired:
+		janitorRuns.WithLabelValues("skipped").Inc()
+		return nil, ErrJanitorBusy
+	}
+
+	janitorRuns.WithLabelValues("success").Inc()
+	janitorLastSuccess.SetToCurrentTime()
+	return purged, nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectDeletes expects one batch delete from table per entry of batches,
+// each deleting that many rows out of a batch of 2, and returns a capture of
+// the cutoff.
+func expectDeletes(mock sqlmock.Sqlmock, table string, batches ...int64) *capture {
+	cutoff := &capture{}
+	for _, deleted := range batches {
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "`+table+`" WHERE id IN (SELECT "id" FROM "`+table+`" WHERE`)).
+			WithArgs(cutoff, 2).
+			WillReturnResult(sqlmock.NewResult(0, deleted))
+	}
+	return cutoff
+}
+
+func TestPurgeDeletesInBatchesAndKeepsRecentOutboxMessages(t *testing.T) {
+	db, mock := newMockDB(t)
+	j := &TokenJanitor{
+		authRepo:  repositories.NewAuthRepository(db, newTestHasher(t)),
+		tx:        repositories.NewTransactor(db),
+		batchSize: 2,
+	}
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock`).WithArgs(janitorLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	cutoffs := make(map[string]*capture, len(purgeable))
+	for _, table := range purgeable {
+		switch table.name {
+		case "verification_tokens":
+			// Full batches mean there may be more; a short one ends the table.
+			cutoffs[table.name] = expectDeletes(mock, table.name, 2, 2, 1)
+		case "reset_tokens":
+			cutoffs[table.name] = expectDeletes(mock, table.name, 2, 0)
+		default:
+			cutoffs[table.name] = expectDeletes(mock, table.name, 0)
+		}
+	}
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(janitorLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	before := time.Now()
+	purged, err := j.Purge(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if purged["verification_tokens"] != 5 || purged["reset_tokens"] != 2 {
+		t.Errorf("purged = %v, want 5 verification tokens and 2 reset tokens", purged)
+	}
+
+	start, ok := cutoffs["sessions"].value.(time.Time)
+	if !ok || start.Before(before) {
+		t.Fatalf("sessions cutoff = %v, want the start of the purge", cutoffs["sessions"].value)
+	}
+	delivered, ok := cutoffs["outbox_messages"].value.(time.Time)
+	if !ok || !delivered.Equal(start.Add(-deliveredOutboxRetention)) {
+		t.Errorf("outbox cutoff = %v, want %v", cutoffs["outbox_messages"].value, start.Add(-deliveredOutboxRetention))
+	}
+}
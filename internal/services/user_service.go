@@ -1,47 +1,73 @@
 package services
 
 import (
-	"errors"
 	"skyphin-api/internal/models"
 	"skyphin-api/internal/repositories"
+)
 
-	"golang.org/x/crypto/bcrypt"
+// The repository also returns these when the username or email is taken
+// between the check in NewUser and the insert.
+var (
+	ErrUsernameTaken = repositories.ErrUsernameTaken
+	ErrEmailTaken    = repositories.ErrEmailTaken
 )
 
 type UserService struct {
-	repo *repositories.UserRepository
+	repo      *repositories.UserRepository
+	passwords *PasswordService
 }
 
-func NewUserService(repo *repositories.UserRepository) *UserService {
-	return &UserService{repo: repo}
+func NewUserService(repo *repositories.UserRepository, passwords *PasswordService) *UserService {
+	return &UserService{repo: repo, passwords: passwords}
 }
 
 func (s *UserService) GetUserByID(id uint) (*models.User, error) {
 	return s.repo.FindByID(id)
 }
 
+func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
+	return s.repo.FindByEmail(email)
+}
+
 func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 	return s.repo.FindByUsername(username)
 }
 
 func (s *UserService) CreateUser(req *models.CreateUserRequest) error {
+	user, err := s.NewUser(req)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.Create(user)
+}
+
+// NewUser validates the request against the password policy and returns an
+// unsaved user with a hashed password.
+func (s *UserService) NewUser(req *models.CreateUserRequest) (*models.User, error) {
 	if _, err := s.repo.FindByUsername(req.Username); err == nil {
-		return errors.New("username already exists")
+		return nil, ErrUsernameTaken
 	}
 	if _, err := s.repo.FindByEmail(req.Email); err == nil {
-		return errors.New("email already exists")
+		return nil, ErrEmailTaken
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+	user := &models.User{
+		Username: req.Username,
+		Email:    req.Email,
 	}
 
-	user := &models.User{
-		Username:          req.Username,
-		Email:             req.Email,
-		EncryptedPassword: string(hashedPassword),
+	if err := s.passwords.Validate(user, req.Password); err != nil {
+		return nil, err
 	}
 
-	return s.repo.Create(user)
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user.EncryptedPassword = hashedPassword
+	user.PasswordHistory = []models.PasswordHistory{{EncryptedPassword: hashedPassword}}
+
+	return user, nil
 }
@@ -0,0 +1,401 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	webAuthnRegistration  = "registration"
+	webAuthnLogin         = "login"
+	webAuthnSessionExpiry = 5 * time.Minute
+	decoyCredentialIDSize = 32
+)
+
+var errWebAuthnNotConfigured = errors.New("WebAuthn is not configured")
+
+type WebAuthnService struct {
+	userRepo     *repositories.UserRepository
+	webAuthnRepo *repositories.WebAuthnRepository
+	webAuthn     *webauthn.WebAuthn
+	lockout      *LockoutService
+	audit        *AuditService
+	decoyKey     []byte
+}
+
+// NewWebAuthnService returns a service whose ceremonies fail with an error
+// when WEBAUTHN_RP_ID is not set.
+func NewWebAuthnService(userRepo *repositories.UserRepository, webAuthnRepo *repositories.WebAuthnRepository, lockout *LockoutService, audit *AuditService, cfg config.Config) (*WebAuthnService, error) {
+	s := &WebAuthnService{userRepo: userRepo, webAuthnRepo: webAuthnRepo, lockout: lockout, audit: audit, decoyKey: []byte("webauthn-decoy:" + cfg.Auth.TokenHashKey)}
+	if cfg.WebAuthn.RPID == "" {
+		return s, nil
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthn.RPID,
+		RPDisplayName: cfg.WebAuthn.RPDisplayName,
+		RPOrigins:     cfg.WebAuthn.RPOrigins,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.webAuthn = w
+
+	return s, nil
+}
+
+// BeginRegistration starts registering a new passkey for the user and returns
+// the session token to send back with FinishRegistration.
+func (s *WebAuthnService) BeginRegistration(userID uint) (string, *protocol.CredentialCreation, error) {
+	if s.webAuthn == nil {
+		return "", nil, errWebAuthnNotConfigured
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	creation, session, err := s.webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := s.saveSession(userID, webAuthnRegistration, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, creation, nil
+}
+
+func (s *WebAuthnService) FinishRegistration(userID uint, req *models.WebAuthnFinishRequest) (*models.WebAuthnCredential, error) {
+	if s.webAuthn == nil {
+		return nil, errWebAuthnNotConfigured
+	}
+
+	session, err := s.takeSession(req.SessionToken, webAuthnRegistration)
+	if err != nil || session.UserID != userID {
+		return nil, errors.New("invalid or expired WebAuthn session")
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &sessionData); err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Credential)
+	if err != nil {
+		return nil, errors.New("invalid credential")
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.webAuthn.CreateCredential(user, sessionData, parsed)
+	if err != nil {
+		return nil, errors.New("credential verification failed")
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "Passkey"
+	}
+
+	record := &models.WebAuthnCredential{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: credential.ID,
+		Data:         data,
+	}
+	if err := s.webAuthnRepo.CreateCredential(record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// BeginLogin starts an assertion. With an empty email the browser is asked
+// for any discoverable credential for this relying party. An email without
+// passkeys gets a decoy challenge, so that the answer does not tell whether
+// an account exists.
+func (s *WebAuthnService) BeginLogin(email string) (string, *protocol.CredentialAssertion, error) {
+	if s.webAuthn == nil {
+		return "", nil, errWebAuthnNotConfigured
+	}
+
+	var (
+		userID    uint
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		err       error
+	)
+
+	if email == "" {
+		assertion, session, err = s.webAuthn.BeginDiscoverableLogin()
+	} else {
+		found, findErr := s.userRepo.FindByEmail(email)
+		if findErr != nil {
+			return s.beginDecoyLogin(email)
+		}
+
+		user, loadErr := s.loadUser(found.ID)
+		if loadErr != nil {
+			return "", nil, loadErr
+		}
+		if len(user.credentials) == 0 {
+			return s.beginDecoyLogin(email)
+		}
+
+		userID = found.ID
+		assertion, session, err = s.webAuthn.BeginLogin(user)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := s.saveSession(userID, webAuthnLogin, session)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, assertion, nil
+}
+
+// beginDecoyLogin returns a challenge for a made-up credential whose ID is
+// derived from email, so that repeated requests see the same one. Nothing is
+// saved, so finishing it fails like an expired session.
+func (s *WebAuthnService) beginDecoyLogin(email string) (string, *protocol.CredentialAssertion, error) {
+	mac := hmac.New(sha256.New, s.decoyKey)
+	mac.Write([]byte(strings.ToLower(email)))
+	credentialID := mac.Sum(nil)[:decoyCredentialIDSize]
+
+	decoy := &webAuthnUser{
+		user:        &models.User{Email: email},
+		credentials: []webauthn.Credential{{ID: credentialID}},
+	}
+	assertion, _, err := s.webAuthn.BeginLogin(decoy)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, assertion, nil
+}
+
+// FinishLogin verifies the assertion and returns the authenticated user.
+func (s *WebAuthnService) FinishLogin(req *models.WebAuthnFinishRequest, client models.ClientInfo) (*models.User, error) {
+	if s.webAuthn == nil {
+		return nil, errWebAuthnNotConfigured
+	}
+
+	session, err := s.takeSession(req.SessionToken, webAuthnLogin)
+	if err != nil {
+		s.recordLoginFailure(0, "invalid_session", client)
+		return nil, errors.New("invalid or expired WebAuthn session")
+	}
+
+	var sessionData webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &sessionData); err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(req.Credential)
+	if err != nil {
+		return nil, errors.New("invalid credential")
+	}
+
+	var (
+		user       *webAuthnUser
+		credential *webauthn.Credential
+	)
+	if session.UserID == 0 {
+		var found webauthn.User
+		found, credential, err = s.webAuthn.ValidatePasskeyLogin(s.discoverUser, sessionData, parsed)
+		if err == nil {
+			user = found.(*webAuthnUser)
+		}
+	} else {
+		user, err = s.loadUser(session.UserID)
+		if err != nil {
+			return nil, err
+		}
+		credential, err = s.webAuthn.ValidateLogin(user, sessionData, parsed)
+	}
+	if err != nil {
+		s.recordLoginFailure(session.UserID, "invalid_assertion", client)
+		return nil, errors.New("assertion verification failed")
+	}
+
+	if credential.Authenticator.CloneWarning {
+		s.recordLoginFailure(user.user.ID, "cloned_authenticator", client)
+		return nil, errors.New("authenticator may be cloned")
+	}
+
+	if s.lockout.IsLocked(user.user) {
+		s.recordLoginFailure(user.user.ID, "account_locked", client)
+		return nil, ErrAccountLocked
+	}
+
+	if err := s.recordCredentialUse(user, credential); err != nil {
+		return nil, err
+	}
+
+	return user.user, nil
+}
+
+func (s *WebAuthnService) recordLoginFailure(userID uint, reason string, client models.ClientInfo) {
+	s.audit.Record(AuditEntry{Type: AuditLoginFailed, UserID: userID, Client: client, Details: map[string]string{"method": "webauthn", "reason": reason}})
+}
+
+func (s *WebAuthnService) ListCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	return s.webAuthnRepo.FindCredentialsByUserID(userID)
+}
+
+func (s *WebAuthnService) DeleteCredential(userID uint, id uint) error {
+	return s.webAuthnRepo.DeleteCredential(userID, id)
+}
+
+func (s *WebAuthnService) recordCredentialUse(user *webAuthnUser, credential *webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	for i := range user.records {
+		record := &user.records[i]
+		if string(record.CredentialID) == string(credential.ID) {
+			now := time.Now()
+			record.Data = data
+			record.LastUsedAt = &now
+			return s.webAuthnRepo.UpdateCredential(record)
+		}
+	}
+
+	return errors.New("credential not found")
+}
+
+func (s *WebAuthnService) saveSession(userID uint, ceremony string, sessionData *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(sessionData)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	session := &models.WebAuthnSession{
+		UserID:    userID,
+		Token:     token,
+		Ceremony:  ceremony,
+		Data:      data,
+		ExpiresAt: time.Now().Add(webAuthnSessionExpiry),
+	}
+	if err := s.webAuthnRepo.CreateSession(session); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// takeSession loads a session and deletes it so each challenge is used once.
+func (s *WebAuthnService) takeSession(token string, ceremony string) (*models.WebAuthnSession, error) {
+	session, err := s.webAuthnRepo.FindSession(token, ceremony)
+	if err != nil {
+		return nil, err
+	}
+
+	if deleted, err := s.webAuthnRepo.DeleteSession(token); err != nil {
+		return nil, err
+	} else if !deleted {
+		return nil, errors.New("session already used")
+	}
+
+	if session.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("session expired")
+	}
+
+	return session, nil
+}
+
+func (s *WebAuthnService) discoverUser(rawID, userHandle []byte) (webauthn.User, error) {
+	if len(userHandle) != 8 {
+		return nil, errors.New("invalid user handle")
+	}
+	return s.loadUser(uint(binary.BigEndian.Uint64(userHandle)))
+}
+
+func (s *WebAuthnService) loadUser(userID uint) (*webAuthnUser, error) {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	records, err := s.webAuthnRepo.FindCredentialsByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]webauthn.Credential, 0, len(records))
+	for _, record := range records {
+		var credential webauthn.Credential
+		if err := json.Unmarshal(record.Data, &credential); err != nil {
+			return nil, err
+		}
+		credentials = append(credentials, credential)
+	}
+
+	return &webAuthnUser{user: user, records: records, credentials: credentials}, nil
+}
+
+// webAuthnUser adapts models.User to the webauthn.User interface.
+type webAuthnUser struct {
+	user        *models.User
+	records     []models.WebAuthnCredential
+	credentials []webauthn.Credential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, uint64(u.user.ID))
+	return id
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	return u.credentials
+}
@@ -0,0 +1,417 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"skyphin-api/internal/config"
+	"skyphin-api/internal/models"
+	"skyphin-api/internal/repositories"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const (
+	testRPID   = "skyphin.test"
+	testOrigin = "https://skyphin.test"
+)
+
+// softAuthenticator is a software passkey with a P-256 key and "none"
+// attestation.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+// register answers navigator.credentials.create().
+func (a *softAuthenticator) register(t *testing.T, creation *protocol.CredentialCreation) json.RawMessage {
+	t.Helper()
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         int64(webauthncose.P256),
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	binary.Write(&attested, binary.BigEndian, uint16(len(a.credentialID)))
+	attested.Write(a.credentialID)
+	attested.Write(publicKey)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	authData := append(a.authData(flags), attested.Bytes()...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{"fmt": "none", "attStmt": map[string]any{}, "authData": authData})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encode(clientData(t, "webauthn.create", creation.Response.Challenge)),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// login answers navigator.credentials.get() for userHandle.
+func (a *softAuthenticator) login(t *testing.T, assertion *protocol.CredentialAssertion, userHandle []byte) json.RawMessage {
+	t.Helper()
+
+	a.signCount++
+	authData := a.authData(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	client := clientData(t, "webauthn.get", assertion.Response.Challenge)
+
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encode(client),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(userHandle),
+	})
+}
+
+func (a *softAuthenticator) authData(flags protocol.AuthenticatorFlags) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+	data := append(rpIDHash[:], byte(flags))
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) json.RawMessage {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]any{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": encode(challenge), "origin": testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newTestWebAuthnService(t *testing.T) (*WebAuthnService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock := newMockDB(t)
+	hasher := newTestHasher(t)
+
+	cfg := config.Config{
+		Auth:     config.AuthConfig{TokenHashKey: testTokenHashKey},
+		WebAuthn: config.WebAuthnConfig{RPID: testRPID, RPDisplayName: "Skyphin", RPOrigins: []string{testOrigin}},
+	}
+	audit := NewAuditService(repositories.NewAuditRepository(db))
+	userRepo := repositories.NewUserRepository(db)
+	lockout := NewLockoutService(userRepo, repositories.NewLockoutRepository(db, hasher), nil, nil, audit, cfg)
+	s, err := NewWebAuthnService(userRepo, repositories.NewWebAuthnRepository(db, hasher), lockout, audit, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, mock
+}
+
+var userColumns = []string{"id", "username", "email", "verified"}
+
+func expectUser(mock sqlmock.Sqlmock, query string, user *models.User) {
+	mock.ExpectQuery(query).WillReturnRows(sqlmock.NewRows(userColumns).AddRow(user.ID, user.Username, user.Email, user.Verified))
+}
+
+func expectCredentials(mock sqlmock.Sqlmock, userID uint, stored ...*capture) {
+	rows := sqlmock.NewRows([]string{"id", "user_id", "name", "credential_id", "data"})
+	for i, data := range stored {
+		var credential struct{ ID []byte }
+		json.Unmarshal(data.bytes(), &credential)
+		rows.AddRow(i+1, userID, "Passkey", credential.ID, data.bytes())
+	}
+	mock.ExpectQuery(`FROM "web_authn_credentials" WHERE user_id = \$1`).WithArgs(userID).WillReturnRows(rows)
+}
+
+// expectSession expects a session to be saved, and returns a capture of its
+// data.
+func expectSession(mock sqlmock.Sqlmock, userID uint, ceremony string) *capture {
+	data := &capture{}
+	mock.ExpectQuery(`INSERT INTO "web_authn_sessions"`).
+		WithArgs(userID, sqlmock.AnyArg(), ceremony, data, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	return data
+}
+
+// expectTakeSession expects the saved session to be found and consumed.
+func expectTakeSession(mock sqlmock.Sqlmock, userID uint, ceremony string, data *capture, consumed bool) {
+	mock.ExpectQuery(`FROM "web_authn_sessions" WHERE token_hash = \$1 AND ceremony = \$2`).
+		WithArgs(sqlmock.AnyArg(), ceremony, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "ceremony", "data", "expires_at"}).
+			AddRow(1, userID, ceremony, data.bytes(), time.Now().Add(time.Minute)))
+
+	rows := int64(0)
+	if consumed {
+		rows = 1
+	}
+	mock.ExpectExec(`DELETE FROM "web_authn_sessions"`).WillReturnResult(sqlmock.NewResult(0, rows))
+}
+
+func TestWebAuthnRegisterAndLogin(t *testing.T) {
+	s, mock := newTestWebAuthnService(t)
+	user := &models.User{ID: 42, Username: "jane", Email: "jane@example.com", Verified: true}
+	authenticator := newSoftAuthenticator(t)
+
+	// Registration.
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	registration := expectSession(mock, user.ID, webAuthnRegistration)
+
+	token, creation, err := s.BeginRegistration(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTakeSession(mock, user.ID, webAuthnRegistration, registration, true)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	stored := &capture{}
+	mock.ExpectQuery(`INSERT INTO "web_authn_credentials"`).
+		WithArgs(user.ID, "Laptop", authenticator.credentialID, stored, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+	credential, err := s.FinishRegistration(user.ID, &models.WebAuthnFinishRequest{
+		SessionToken: token,
+		Name:         "Laptop",
+		Credential:   authenticator.register(t, creation),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(credential.CredentialID, authenticator.credentialID) {
+		t.Fatalf("stored credential ID %x, want %x", credential.CredentialID, authenticator.credentialID)
+	}
+
+	// Login by email.
+	expectUser(mock, `FROM "users" WHERE lower\(email\) = lower\(\$1\)`, user)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID, stored)
+	login := expectSession(mock, user.ID, webAuthnLogin)
+
+	token, assertion, err := s.BeginLogin(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed := assertion.Response.AllowedCredentials; len(allowed) != 1 || !bytes.Equal(allowed[0].CredentialID, authenticator.credentialID) {
+		t.Fatalf("allowed credentials = %v", allowed)
+	}
+
+	expectTakeSession(mock, user.ID, webAuthnLogin, login, true)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID, stored)
+	mock.ExpectExec(`UPDATE "web_authn_credentials"`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	response := authenticator.login(t, assertion, (&webAuthnUser{user: user}).WebAuthnID())
+	loggedIn, err := s.FinishLogin(&models.WebAuthnFinishRequest{SessionToken: token, Credential: response}, models.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.ID != user.ID {
+		t.Fatalf("logged in user %d, want %d", loggedIn.ID, user.ID)
+	}
+}
+
+func TestWebAuthnFinishLoginRejectsWrongKey(t *testing.T) {
+	s, mock := newTestWebAuthnService(t)
+	user := &models.User{ID: 42, Username: "jane", Email: "jane@example.com", Verified: true}
+	authenticator := newSoftAuthenticator(t)
+
+	// Register the authenticator's credential ID with another key.
+	registered := newSoftAuthenticator(t)
+	registered.credentialID = authenticator.credentialID
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	registration := expectSession(mock, user.ID, webAuthnRegistration)
+	token, creation, err := s.BeginRegistration(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTakeSession(mock, user.ID, webAuthnRegistration, registration, true)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	stored := &capture{}
+	mock.ExpectQuery(`INSERT INTO "web_authn_credentials"`).
+		WithArgs(user.ID, "Passkey", registered.credentialID, stored, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if _, err := s.FinishRegistration(user.ID, &models.WebAuthnFinishRequest{SessionToken: token, Credential: registered.register(t, creation)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUser(mock, `FROM "users" WHERE lower\(email\) = lower\(\$1\)`, user)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID, stored)
+	login := expectSession(mock, user.ID, webAuthnLogin)
+	token, assertion, err := s.BeginLogin(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectTakeSession(mock, user.ID, webAuthnLogin, login, true)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID, stored)
+
+	details := expectAudit(mock, AuditLoginFailed, user.ID)
+
+	response := authenticator.login(t, assertion, (&webAuthnUser{user: user}).WebAuthnID())
+	if _, err := s.FinishLogin(&models.WebAuthnFinishRequest{SessionToken: token, Credential: response}, models.ClientInfo{}); err == nil {
+		t.Fatal("expected an assertion signed with another key to be rejected")
+	}
+	if got := string(details.bytes()); got != `{"method":"webauthn","reason":"invalid_assertion"}` {
+		t.Errorf("audit details = %s", got)
+	}
+}
+
+func TestWebAuthnFinishLoginRefusesLockedAccount(t *testing.T) {
+	s, mock := newTestWebAuthnService(t)
+	user := &models.User{ID: 42, Username: "jane", Email: "jane@example.com", Verified: true}
+	authenticator := newSoftAuthenticator(t)
+
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	registration := expectSession(mock, user.ID, webAuthnRegistration)
+	token, creation, err := s.BeginRegistration(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTakeSession(mock, user.ID, webAuthnRegistration, registration, true)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID)
+	stored := &capture{}
+	mock.ExpectQuery(`INSERT INTO "web_authn_credentials"`).
+		WithArgs(user.ID, "Passkey", authenticator.credentialID, stored, nil, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	if _, err := s.FinishRegistration(user.ID, &models.WebAuthnFinishRequest{SessionToken: token, Credential: authenticator.register(t, creation)}); err != nil {
+		t.Fatal(err)
+	}
+
+	expectUser(mock, `FROM "users" WHERE lower\(email\) = lower\(\$1\)`, user)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, user)
+	expectCredentials(mock, user.ID, stored)
+	login := expectSession(mock, user.ID, webAuthnLogin)
+	token, assertion, err := s.BeginLogin(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The account is locked after the ceremony began.
+	expectTakeSession(mock, user.ID, webAuthnLogin, login, true)
+	mock.ExpectQuery(`FROM "users" WHERE "users"."id" = \$1`).
+		WillReturnRows(sqlmock.NewRows(append(userColumns, "locked_until")).
+			AddRow(user.ID, user.Username, user.Email, user.Verified, time.Now().Add(time.Hour)))
+	expectCredentials(mock, user.ID, stored)
+	details := expectAudit(mock, AuditLoginFailed, user.ID)
+
+	response := authenticator.login(t, assertion, (&webAuthnUser{user: user}).WebAuthnID())
+	if _, err := s.FinishLogin(&models.WebAuthnFinishRequest{SessionToken: token, Credential: response}, models.ClientInfo{}); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("err = %v, want ErrAccountLocked", err)
+	}
+	if got := string(details.bytes()); got != `{"method":"webauthn","reason":"account_locked"}` {
+		t.Errorf("audit details = %s", got)
+	}
+}
+
+func TestWebAuthnFinishLoginRejectsConsumedSession(t *testing.T) {
+	s, mock := newTestWebAuthnService(t)
+	user := &models.User{ID: 42, Email: "jane@example.com"}
+
+	data, err := json.Marshal(map[string]string{"challenge": "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectTakeSession(mock, user.ID, webAuthnLogin, &capture{value: data}, false)
+	expectAudit(mock, AuditLoginFailed, 0)
+
+	_, err = s.FinishLogin(&models.WebAuthnFinishRequest{SessionToken: "token", Credential: json.RawMessage(`{}`)}, models.ClientInfo{})
+	if err == nil || err.Error() != "invalid or expired WebAuthn session" {
+		t.Fatalf("err = %v, want an invalid session", err)
+	}
+}
+
+func TestWebAuthnBeginLoginDoesNotRevealUnknownEmails(t *testing.T) {
+	s, mock := newTestWebAuthnService(t)
+	known := &models.User{ID: 7, Email: "nopasskey@example.com"}
+
+	// Unknown email, twice, then a user without passkeys.
+	for range 2 {
+		mock.ExpectQuery(`FROM "users" WHERE lower\(email\) = lower\(\$1\)`).WithArgs("ghost@example.com", 1).WillReturnRows(sqlmock.NewRows(userColumns))
+	}
+	expectUser(mock, `FROM "users" WHERE lower\(email\) = lower\(\$1\)`, known)
+	expectUser(mock, `FROM "users" WHERE "users"."id" = \$1`, known)
+	expectCredentials(mock, known.ID)
+
+	var allowed [][]byte
+	for _, email := range []string{"ghost@example.com", "ghost@example.com", known.Email} {
+		token, assertion, err := s.BeginLogin(email)
+		if err != nil {
+			t.Fatalf("BeginLogin(%s): %v", email, err)
+		}
+		if token == "" {
+			t.Fatalf("BeginLogin(%s) returned no session token", email)
+		}
+		credentials := assertion.Response.AllowedCredentials
+		if len(credentials) != 1 || len(credentials[0].CredentialID) != decoyCredentialIDSize {
+			t.Fatalf("BeginLogin(%s) allowed credentials = %v, want one decoy", email, credentials)
+		}
+		allowed = append(allowed, credentials[0].CredentialID)
+	}
+
+	if !bytes.Equal(allowed[0], allowed[1]) {
+		t.Error("decoy credential changed between requests for the same email")
+	}
+	if bytes.Equal(allowed[0], allowed[2]) {
+		t.Error("different emails got the same decoy credential")
+	}
+}
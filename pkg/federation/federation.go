@@ -0,0 +1,229 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"skyphin-api/internal/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Identity is what a provider tells us about the user who signed in.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// Connector runs the authorization code flow with PKCE against one provider,
+// either as OpenID Connect or, for providers such as GitHub, as plain OAuth2
+// with a user info endpoint.
+type Connector interface {
+	// AuthCodeURL is where to send the browser to sign in. nonce is only
+	// used by OpenID Connect providers.
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+	// Exchange redeems the code from the callback and returns the identity.
+	Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error)
+}
+
+// New returns a connector for the provider. Provider metadata is discovered
+// on first use, so a provider that is down does not stop the server.
+func New(cfg config.FederatedProviderConfig, redirectURL string) (Connector, error) {
+	if cfg.ClientID == "" {
+		return nil, fmt.Errorf("federated provider %s: client ID is required", cfg.Name)
+	}
+
+	if cfg.Issuer != "" {
+		return &oidcConnector{cfg: cfg, redirectURL: redirectURL}, nil
+	}
+
+	if cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "" {
+		return nil, fmt.Errorf("federated provider %s: an issuer, or auth, token and user info URLs are required", cfg.Name)
+	}
+	return &oauth2Connector{
+		cfg: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     oauth2.Endpoint{AuthURL: cfg.AuthURL, TokenURL: cfg.TokenURL},
+			RedirectURL:  redirectURL,
+			Scopes:       cfg.Scopes,
+		},
+	}, nil
+}
+
+type oidcConnector struct {
+	cfg         config.FederatedProviderConfig
+	redirectURL string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func (c *oidcConnector) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	oauth, _, err := c.setup(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (c *oidcConnector) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	oauth, provider, err := c.setup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("provider did not return an ID token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: c.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	// Some providers keep the email out of the ID token.
+	if claims.Email == "" && provider.UserInfoEndpoint() != "" {
+		info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, err
+		}
+		claims.Email, claims.EmailVerified = info.Email, info.EmailVerified
+	}
+
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+func (c *oidcConnector) setup(ctx context.Context) (*oauth2.Config, *oidc.Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider == nil {
+		provider, err := oidc.NewProvider(ctx, c.cfg.Issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("federated provider %s: %w", c.cfg.Name, err)
+		}
+		c.provider = provider
+	}
+
+	scopes := c.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return &oauth2.Config{
+		ClientID:     c.cfg.ClientID,
+		ClientSecret: c.cfg.ClientSecret,
+		Endpoint:     c.provider.Endpoint(),
+		RedirectURL:  c.redirectURL,
+		Scopes:       scopes,
+	}, c.provider, nil
+}
+
+type oauth2Connector struct {
+	cfg   config.FederatedProviderConfig
+	oauth oauth2.Config
+}
+
+func (c *oauth2Connector) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	return c.oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (c *oauth2Connector) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	token, err := c.oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.oauth.Client(ctx, token).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("user info request failed with status %d", resp.StatusCode)
+	}
+
+	var info map[string]any
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&info); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject:  claimString(info, valueOr(c.cfg.SubjectClaim, "sub")),
+		Email:    claimString(info, valueOr(c.cfg.EmailClaim, "email")),
+		Username: claimString(info, valueOr(c.cfg.UsernameClaim, "preferred_username")),
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("user info has no subject")
+	}
+
+	if c.cfg.EmailVerifiedClaim != "" {
+		identity.EmailVerified, _ = strconv.ParseBool(claimString(info, c.cfg.EmailVerifiedClaim))
+	} else {
+		identity.EmailVerified = c.cfg.TrustEmail && identity.Email != ""
+	}
+
+	return identity, nil
+}
+
+func claimString(claims map[string]any, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return ""
+	}
+}
+
+func valueOr(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,214 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"skyphin-api/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "skyphin"
+	testRedirectURL = "https://auth.skyphin.test/login/federated/mock/callback"
+	testVerifier    = "0123456789abcdef0123456789abcdef0123456789abcdef"
+)
+
+// mockProvider is an OpenID Connect provider that signs in one user. It
+// remembers the PKCE challenge of the last authorization request and only
+// hands out tokens for the matching verifier.
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+
+	challenge string
+	nonce     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, claims: jwt.MapClaims{"email": "jane@example.com", "email_verified": true, "preferred_username": "jane"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /keys", p.keys)
+	mux.HandleFunc("POST /token", p.token)
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *mockProvider) connector(t *testing.T) Connector {
+	t.Helper()
+
+	connector, err := New(config.FederatedProviderConfig{Name: "mock", Issuer: p.server.URL, ClientID: testClientID, ClientSecret: "secret"}, testRedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connector
+}
+
+// authorize plays the browser: it follows authURL as far as the provider
+// would, and returns the code the provider would redirect back with.
+func (p *mockProvider) authorize(t *testing.T, authURL string) string {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL || query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+	p.challenge = query.Get("code_challenge")
+	p.nonce = query.Get("nonce")
+	return "code"
+}
+
+func (p *mockProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                                p.server.URL,
+		"authorization_endpoint":                p.server.URL + "/authorize",
+		"token_endpoint":                        p.server.URL + "/token",
+		"jwks_uri":                              p.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (p *mockProvider) keys(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"use": "sig",
+		"kid": "test",
+		"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}}})
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	digest := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if r.FormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(digest[:]) != p.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   p.server.URL,
+		"sub":   "mock-user",
+		"aud":   testClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": p.nonce,
+	}
+	for name, value := range p.claims {
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"access_token": "access", "token_type": "Bearer", "expires_in": 60, "id_token": idToken})
+}
+
+func TestOIDCConnectorSignsIn(t *testing.T) {
+	provider := newMockProvider(t)
+	connector := provider.connector(t)
+	ctx := context.Background()
+
+	authURL, err := connector.AuthCodeURL(ctx, "state", "nonce", testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := provider.authorize(t, authURL)
+
+	identity, err := connector.Exchange(ctx, code, testVerifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Subject: "mock-user", Email: "jane@example.com", EmailVerified: true, Username: "jane"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCConnectorRejectsWrongVerifier(t *testing.T) {
+	provider := newMockProvider(t)
+	connector := provider.connector(t)
+	ctx := context.Background()
+
+	authURL, err := connector.AuthCodeURL(ctx, "state", "nonce", testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := provider.authorize(t, authURL)
+
+	if _, err := connector.Exchange(ctx, code, "another verifier of at least forty-three characters", "nonce"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestOIDCConnectorRejectsWrongNonce(t *testing.T) {
+	provider := newMockProvider(t)
+	connector := provider.connector(t)
+	ctx := context.Background()
+
+	authURL, err := connector.AuthCodeURL(ctx, "state", "nonce", testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := provider.authorize(t, authURL)
+
+	// The callback belongs to another sign-in than the one the ID token was
+	// issued for.
+	if _, err := connector.Exchange(ctx, code, testVerifier, "another nonce"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestOIDCConnectorReportsUnverifiedEmail(t *testing.T) {
+	provider := newMockProvider(t)
+	provider.claims["email_verified"] = false
+	connector := provider.connector(t)
+	ctx := context.Background()
+
+	authURL, err := connector.AuthCodeURL(ctx, "state", "nonce", testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := connector.Exchange(ctx, provider.authorize(t, authURL), testVerifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.EmailVerified {
+		t.Error("email reported as verified")
+	}
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"skyphin-api/internal/config"
+)
+
+// LogMailer writes the text body of every message to the standard logger.
+// It is meant for local development.
+type LogMailer struct {
+	cfg config.MailConfig
+}
+
+func NewLogMailer(cfg config.MailConfig) *LogMailer {
+	return &LogMailer{cfg: cfg}
+}
+
+func (m *LogMailer) Send(msg *Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// FileMailer writes every message as an .eml file into MAIL_FILE_DIR so it
+// can be opened in a mail client.
+type FileMailer struct {
+	cfg     config.MailConfig
+	counter atomic.Uint64
+}
+
+func NewFileMailer(cfg config.MailConfig) (*FileMailer, error) {
+	if cfg.FileDir == "" {
+		return nil, fmt.Errorf("MAIL_FILE_DIR is required for the file transport")
+	}
+	if err := os.MkdirAll(cfg.FileDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileMailer{cfg: cfg}, nil
+}
+
+func (m *FileMailer) Send(msg *Message) error {
+	from, to, err := parseAddresses(m.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+	data, err := build(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(to.Address)
+	name := fmt.Sprintf("%s-%d-%s.eml", time.Now().Format("20060102T150405"), m.counter.Add(1), recipient)
+	return os.WriteFile(filepath.Join(m.cfg.FileDir, name), data, 0o644)
+}
@@ -0,0 +1,201 @@
+package mailer
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+
+	"skyphin-api/internal/config"
+)
+
+// smtpStandIn is a minimal SMTP server that accepts one message per
+// connection and records the envelope and the data.
+type smtpStandIn struct {
+	listener net.Listener
+	messages chan received
+}
+
+type received struct {
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &smtpStandIn{listener: listener, messages: make(chan received, 1)}
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) config(from string) config.MailConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return config.MailConfig{
+		From:     from,
+		SMTPHost: addr.IP.String(),
+		SMTPPort: addr.Port,
+		SMTPTLS:  "none",
+	}
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	var msg received
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " ")[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			msg.from = line
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, line)
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			lines, err := text.ReadDotLines()
+			if err != nil {
+				return
+			}
+			msg.data = strings.Join(lines, "\r\n")
+			s.messages <- msg
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+// headers parses the header block of data.
+func headers(t *testing.T, data string) textproto.MIMEHeader {
+	t.Helper()
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(data + "\r\n\r\n"))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func TestSMTPMailerSendsMessage(t *testing.T) {
+	server := newSMTPStandIn(t)
+	m := NewSMTPMailer(server.config("Skyphin <noreply@skyphin.test>"))
+
+	err := m.Send(&Message{To: "Jane Doe <jane@example.com>", Subject: "Hello", Text: "text", HTML: "<p>html</p>"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := <-server.messages
+	if msg.from != "MAIL FROM:<noreply@skyphin.test>" {
+		t.Errorf("envelope sender = %q", msg.from)
+	}
+	if len(msg.to) != 1 || msg.to[0] != "RCPT TO:<jane@example.com>" {
+		t.Errorf("envelope recipients = %q", msg.to)
+	}
+
+	header := headers(t, msg.data)
+	if got := header.Get("To"); got != `"Jane Doe" <jane@example.com>` {
+		t.Errorf("To = %q", got)
+	}
+	if got := header.Get("From"); got != `"Skyphin" <noreply@skyphin.test>` {
+		t.Errorf("From = %q", got)
+	}
+	if got := header.Get("Message-Id"); !strings.HasSuffix(got, "@skyphin.test>") {
+		t.Errorf("Message-ID = %q", got)
+	}
+}
+
+func TestSMTPMailerRejectsHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+	}{
+		{"recipient", "noreply@skyphin.test", "jane@example.com\r\nBcc: eve@example.com"},
+		{"recipient display name", "noreply@skyphin.test", "Jane\r\nBcc: eve@example.com <jane@example.com>"},
+		{"sender", "noreply@skyphin.test\r\nBcc: eve@example.com", "jane@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPStandIn(t)
+			m := NewSMTPMailer(server.config(tt.from))
+
+			if err := m.Send(&Message{To: tt.to, Subject: "Hello", Text: "text"}); err == nil {
+				t.Fatal("expected an error")
+			}
+			select {
+			case msg := <-server.messages:
+				t.Fatalf("message was sent: %q", msg.data)
+			default:
+			}
+		})
+	}
+}
+
+func TestSMTPMailerEncodesSubject(t *testing.T) {
+	server := newSMTPStandIn(t)
+	m := NewSMTPMailer(server.config("noreply@skyphin.test"))
+
+	err := m.Send(&Message{To: "jane@example.com", Subject: "Join Acme\r\nBcc: eve@example.com", Text: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := headers(t, (<-server.messages).data)
+	if got := header.Get("Bcc"); got != "" {
+		t.Errorf("Bcc = %q, want none", got)
+	}
+	if got := header.Get("Subject"); !strings.HasPrefix(got, "=?UTF-8?q?") {
+		t.Errorf("Subject = %q, want it encoded", got)
+	}
+}
+
+func TestBuildQuotesDisplayNames(t *testing.T) {
+	from, to, err := parseAddresses("noreply@skyphin.test", &Message{To: `"Doe, Jane" <jane@example.com>`})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := build(from, to, &Message{Subject: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := headers(t, strings.SplitN(string(data), "\r\n\r\n", 2)[0])
+	if got, want := header.Get("To"), strconv.Quote("Doe, Jane")+" <jane@example.com>"; got != want {
+		t.Errorf("To = %q, want %q", got, want)
+	}
+}
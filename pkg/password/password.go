@@ -0,0 +1,240 @@
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"skyphin-api/internal/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+
+	defaultArgon2Memory      = 64 * 1024
+	defaultArgon2Iterations  = 3
+	defaultArgon2Parallelism = 2
+	argon2SaltLength         = 16
+	argon2KeyLength          = 32
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrInvalidHash   = errors.New("invalid password hash")
+	ErrUnknownPepper = errors.New("password hash uses an unknown pepper")
+)
+
+var b64 = base64.RawStdEncoding
+
+// Hasher writes argon2id hashes in PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, or bcrypt hashes, and
+// verifies both.
+//
+// With a pepper, the password is HMAC-SHA256'd with it before argon2id, and
+// the hash records a short fingerprint of the pepper in its "k" parameter.
+// Hashes made before a pepper was configured, or with a previous pepper, keep
+// verifying and are upgraded on the next login. bcrypt hashes are never
+// peppered.
+type Hasher struct {
+	algorithm   string
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	bcryptCost  int
+	pepperID    string
+	// peppers holds the current and previous peppers by fingerprint.
+	peppers map[string][]byte
+}
+
+// New returns a hasher for PASSWORD_HASH_ALGORITHM, "argon2id" (the default)
+// or "bcrypt".
+func New(cfg config.PasswordConfig) (*Hasher, error) {
+	memory := config.IntOr(cfg.Argon2MemoryKiB, defaultArgon2Memory)
+	iterations := config.IntOr(cfg.Argon2Iterations, defaultArgon2Iterations)
+	parallelism := config.IntOr(cfg.Argon2Parallelism, defaultArgon2Parallelism)
+	if memory > math.MaxUint32 {
+		return nil, fmt.Errorf("argon2 memory must be at most %d KiB", uint32(math.MaxUint32))
+	}
+	if iterations > math.MaxUint32 {
+		return nil, fmt.Errorf("argon2 iterations must be at most %d", uint32(math.MaxUint32))
+	}
+	if parallelism > math.MaxUint8 {
+		return nil, fmt.Errorf("argon2 parallelism must be at most %d", math.MaxUint8)
+	}
+
+	h := &Hasher{
+		algorithm:   cfg.Algorithm,
+		memory:      uint32(memory),
+		iterations:  uint32(iterations),
+		parallelism: uint8(parallelism),
+		bcryptCost:  config.IntOr(cfg.BcryptCost, bcrypt.DefaultCost),
+		peppers:     make(map[string][]byte),
+	}
+
+	switch h.algorithm {
+	case "":
+		h.algorithm = AlgorithmArgon2id
+	case AlgorithmArgon2id, AlgorithmBcrypt:
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", cfg.Algorithm)
+	}
+	if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	for _, pepper := range cfg.PreviousPeppers {
+		if pepper != "" {
+			h.peppers[pepperFingerprint([]byte(pepper))] = []byte(pepper)
+		}
+	}
+	if cfg.Pepper != "" {
+		h.pepperID = pepperFingerprint([]byte(cfg.Pepper))
+		h.peppers[h.pepperID] = []byte(cfg.Pepper)
+	}
+
+	return h, nil
+}
+
+// Hash hashes the password with the configured algorithm and parameters.
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	params := argon2Params{memory: h.memory, iterations: h.iterations, parallelism: h.parallelism, pepperID: h.pepperID}
+	key := argon2.IDKey(h.pepperPassword(password, h.pepperID), salt, params.iterations, params.memory, params.parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$%s$%s$%s", AlgorithmArgon2id, argon2.Version, params, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify checks the password against an argon2id or bcrypt hash and returns
+// ErrMismatch if it does not match. On a match, rehash reports whether the
+// hash was made with another algorithm, other parameters or another pepper
+// and should be replaced.
+func (h *Hasher) Verify(password string, encoded string) (rehash bool, err error) {
+	if strings.HasPrefix(encoded, "$2") {
+		if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return false, ErrMismatch
+			}
+			return false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, err
+		}
+		return h.algorithm != AlgorithmBcrypt || cost != h.bcryptCost, nil
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := h.peppers[params.pepperID]; params.pepperID != "" && !ok {
+		return false, ErrUnknownPepper
+	}
+
+	candidate := argon2.IDKey(h.pepperPassword(password, params.pepperID), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, ErrMismatch
+	}
+
+	current := argon2Params{memory: h.memory, iterations: h.iterations, parallelism: h.parallelism, pepperID: h.pepperID}
+	return h.algorithm != AlgorithmArgon2id || params != current || len(key) != argon2KeyLength, nil
+}
+
+func (h *Hasher) pepperPassword(password string, pepperID string) []byte {
+	if pepperID == "" {
+		return []byte(password)
+	}
+	mac := hmac.New(sha256.New, h.peppers[pepperID])
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	pepperID    string
+}
+
+func (p argon2Params) String() string {
+	s := fmt.Sprintf("m=%d,t=%d,p=%d", p.memory, p.iterations, p.parallelism)
+	if p.pepperID != "" {
+		s += ",k=" + p.pepperID
+	}
+	return s
+}
+
+func decodeArgon2id(encoded string) (argon2Params, []byte, []byte, error) {
+	var params argon2Params
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != AlgorithmArgon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "m":
+			_, err = fmt.Sscanf(value, "%d", &params.memory)
+		case "t":
+			_, err = fmt.Sscanf(value, "%d", &params.iterations)
+		case "p":
+			_, err = fmt.Sscanf(value, "%d", &params.parallelism)
+		case "k":
+			params.pepperID = value
+		default:
+			err = ErrInvalidHash
+		}
+		if err != nil {
+			return params, nil, nil, ErrInvalidHash
+		}
+	}
+	if params.memory == 0 || params.iterations == 0 || params.parallelism == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
+
+// pepperFingerprint identifies a pepper without revealing it.
+func pepperFingerprint(pepper []byte) string {
+	sum := sha256.Sum256(pepper)
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,75 @@
+package password
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"skyphin-api/internal/config"
+)
+
+// fastArgon2 keeps the tests quick.
+var fastArgon2 = config.PasswordConfig{Argon2MemoryKiB: 64, Argon2Iterations: 1, Argon2Parallelism: 1}
+
+func newHasher(t *testing.T, pepper string, previous ...string) *Hasher {
+	t.Helper()
+
+	cfg := fastArgon2
+	cfg.Pepper = pepper
+	cfg.PreviousPeppers = previous
+	h, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestVerifyWithPreviousPepper(t *testing.T) {
+	hash, err := newHasher(t, "old pepper").Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newHasher(t, "new pepper").Verify("correct horse", hash); !errors.Is(err, ErrUnknownPepper) {
+		t.Fatalf("Verify without the old pepper = %v, want ErrUnknownPepper", err)
+	}
+
+	rotated := newHasher(t, "new pepper", "old pepper")
+	rehash, err := rotated.Verify("correct horse", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rehash {
+		t.Error("a hash with a previous pepper should be rehashed")
+	}
+	if _, err := rotated.Verify("wrong horse", hash); !errors.Is(err, ErrMismatch) {
+		t.Errorf("Verify with the wrong password = %v, want ErrMismatch", err)
+	}
+
+	upgraded, err := rotated.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rehash, err := newHasher(t, "new pepper").Verify("correct horse", upgraded); err != nil || rehash {
+		t.Errorf("Verify of the upgraded hash = %v, %v", rehash, err)
+	}
+}
+
+func TestNewRejectsOutOfRangeParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.PasswordConfig
+	}{
+		{"memory", config.PasswordConfig{Argon2MemoryKiB: math.MaxUint32 + 1}},
+		{"iterations", config.PasswordConfig{Argon2Iterations: math.MaxUint32 + 1}},
+		{"parallelism", config.PasswordConfig{Argon2Parallelism: 256}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.cfg); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"skyphin-api/internal/config"
+)
+
+const (
+	defaultMinLength = 8
+	defaultMaxLength = 128
+	minIdentityPart  = 4
+)
+
+// commonPasswords are always banned, on top of PASSWORD_BANNED_FILE.
+var commonPasswords = []string{
+	"password", "password1", "password123", "passw0rd", "12345678", "123456789",
+	"1234567890", "qwertyuiop", "qwerty123", "iloveyou", "letmein1", "welcome1",
+	"admin123", "abc12345", "football", "baseball", "sunshine", "princess",
+	"11111111", "00000000", "skyphin", "skyphin123",
+}
+
+// Violation is a single reason a password was rejected.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError lists every rule a password breaks.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Add records a violation found outside the policy, such as password reuse.
+func (e *PolicyError) Add(code string, message string) {
+	e.Violations = append(e.Violations, Violation{Code: code, Message: message})
+}
+
+// BreachedCorpus reports whether a password appears in a known breach.
+type BreachedCorpus interface {
+	Contains(password string) (bool, error)
+}
+
+// Policy checks new passwords against length limits, a banned list, the
+// user's own identifiers and an optional breached-password corpus.
+type Policy struct {
+	minLength int
+	maxLength int
+	banned    map[string]struct{}
+	breached  BreachedCorpus
+}
+
+func NewPolicy(cfg config.PasswordConfig) (*Policy, error) {
+	p := &Policy{
+		minLength: config.IntOr(cfg.MinLength, defaultMinLength),
+		maxLength: config.IntOr(cfg.MaxLength, defaultMaxLength),
+		banned:    make(map[string]struct{}),
+	}
+	if p.minLength > p.maxLength {
+		return nil, errors.New("PASSWORD_MIN_LENGTH is greater than PASSWORD_MAX_LENGTH")
+	}
+
+	for _, password := range commonPasswords {
+		p.banned[password] = struct{}{}
+	}
+	if cfg.BannedFile != "" {
+		if err := p.loadBanned(cfg.BannedFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.BreachedDir != "" {
+		info, err := os.Stat(cfg.BreachedDir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", cfg.BreachedDir)
+		}
+		p.breached = PrefixDirectory(cfg.BreachedDir)
+	}
+
+	return p, nil
+}
+
+// Check returns a *PolicyError listing every violated rule, or nil.
+// identifiers are values such as the username and email that the password
+// must not resemble.
+func (p *Policy) Check(password string, identifiers ...string) error {
+	violations := &PolicyError{}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		violations.Add("too_short", fmt.Sprintf("must be at least %d characters", p.minLength))
+	}
+	if length > p.maxLength {
+		violations.Add("too_long", fmt.Sprintf("must be at most %d characters", p.maxLength))
+	}
+
+	normalized := strings.ToLower(password)
+	if _, ok := p.banned[normalized]; ok {
+		violations.Add("banned", "is too common")
+	}
+
+	if resemblesIdentity(normalized, identifiers) {
+		violations.Add("similar_to_identity", "must not contain your username or email")
+	}
+
+	if p.breached != nil && len(violations.Violations) == 0 {
+		found, err := p.breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if found {
+			violations.Add("breached", "has appeared in a data breach")
+		}
+	}
+
+	if len(violations.Violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+func (p *Policy) loadBanned(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			p.banned[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// resemblesIdentity reports whether the password contains, or is contained
+// in, the identifiers or the parts of an email address.
+func resemblesIdentity(password string, identifiers []string) bool {
+	for _, identifier := range identifiers {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+		parts := []string{identifier}
+		if local, domain, ok := strings.Cut(identifier, "@"); ok {
+			name, _, _ := strings.Cut(domain, ".")
+			parts = append(parts, local, name)
+		}
+
+		for _, part := range parts {
+			if len(part) < minIdentityPart {
+				continue
+			}
+			if strings.Contains(password, part) || strings.Contains(part, password) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PrefixDirectory is a breached-password corpus laid out like the Have I Been
+// Pwned range API: one file per upper-case five character SHA-1 prefix, e.g.
+// 5BAA6.txt, holding "SUFFIX:COUNT" lines. The official downloader writes
+// this layout.
+type PrefixDirectory string
+
+func (d PrefixDirectory) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(scanner.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(candidate), suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
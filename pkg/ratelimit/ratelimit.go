@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"skyphin-api/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit allows Requests per Period with bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// rate returns the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Policy is a named limit together with what the bucket is keyed by: "ip",
+// "user", "field:<name>" for a field of the JSON request body or
+// "cookie:<name>" for a cookie.
+type Policy struct {
+	Name  string
+	Limit Limit
+	Key   string
+}
+
+// Result describes the state of a bucket after taking a token.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the bucket is full again.
+	RetryAfter time.Duration // Until the next token, when not allowed.
+}
+
+// Store keeps token buckets. Implementations must be safe for concurrent use.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// New returns the store selected by RATE_LIMIT_BACKEND: "memory" (the
+// default), "redis", or "off", for which it returns nil.
+func New(cfg config.RateLimitConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedisStore(redis.NewClient(opts), "ratelimit:"), nil
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", cfg.Backend)
+	}
+}
+
+// ParsePolicy parses "name=requests/period@key", e.g. "login=10/1m@ip" or
+// "reset=3/1h@field:email".
+func ParsePolicy(spec string) (Policy, error) {
+	name, rest, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok || name == "" {
+		return Policy{}, fmt.Errorf("invalid rate limit policy %q", spec)
+	}
+
+	rule, key, ok := strings.Cut(rest, "@")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit policy %q has no key", spec)
+	}
+	if key != "ip" && key != "user" && !strings.HasPrefix(key, "field:") && !strings.HasPrefix(key, "cookie:") {
+		return Policy{}, fmt.Errorf("rate limit policy %q has unknown key %q", spec, key)
+	}
+
+	requests, period, ok := strings.Cut(rule, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("invalid rate limit policy %q", spec)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("invalid request count in rate limit policy %q", spec)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("invalid period in rate limit policy %q", spec)
+	}
+
+	return Policy{Name: name, Limit: Limit{Requests: n, Period: d}, Key: key}, nil
+}
+
+// newResult builds the result from the tokens left in the bucket.
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.rate()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     secondsToDuration((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	return result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
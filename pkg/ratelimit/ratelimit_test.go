@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Policy
+	}{
+		{"login=10/1m@ip", Policy{Name: "login", Limit: Limit{Requests: 10, Period: time.Minute}, Key: "ip"}},
+		{" api=600/1m@user ", Policy{Name: "api", Limit: Limit{Requests: 600, Period: time.Minute}, Key: "user"}},
+		{"reset=3/1h@field:email", Policy{Name: "reset", Limit: Limit{Requests: 3, Period: time.Hour}, Key: "field:email"}},
+		{"binding=10/15m@cookie:magic_link_binding", Policy{Name: "binding", Limit: Limit{Requests: 10, Period: 15 * time.Minute}, Key: "cookie:magic_link_binding"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParsePolicy(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePolicy(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolicyRejectsMalformedSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"10/1m@ip",
+		"=10/1m@ip",
+		"login=10/1m",
+		"login=10/1m@host",
+		"login=10@ip",
+		"login=ten/1m@ip",
+		"login=0/1m@ip",
+		"login=-1/1m@ip",
+		"login=10/minute@ip",
+		"login=10/0s@ip",
+	} {
+		if _, err := ParsePolicy(spec); err == nil {
+			t.Errorf("ParsePolicy(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestMemoryStoreRefills(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 2, Period: 2 * time.Second}
+	ctx := context.Background()
+
+	for i := range 2 {
+		result, err := store.Take(ctx, "key", limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Allowed || result.Remaining != 1-i || result.Limit != 2 {
+			t.Fatalf("take %d = %+v, want allowed with %d remaining", i, result, 1-i)
+		}
+	}
+
+	result, _ := store.Take(ctx, "key", limit)
+	if result.Allowed {
+		t.Fatal("took more than the bucket holds")
+	}
+	// One token per second.
+	if result.RetryAfter <= 0 || result.RetryAfter > time.Second {
+		t.Errorf("RetryAfter = %v, want up to a second", result.RetryAfter)
+	}
+	if result.Reset <= time.Second || result.Reset > 2*time.Second {
+		t.Errorf("Reset = %v, want up to two seconds", result.Reset)
+	}
+
+	if other, _ := store.Take(ctx, "other", limit); !other.Allowed {
+		t.Error("buckets are not separate")
+	}
+
+	// A second later the bucket holds one token again.
+	store.buckets["key"].updated = store.buckets["key"].updated.Add(-time.Second)
+	if result, _ := store.Take(ctx, "key", limit); !result.Allowed {
+		t.Errorf("bucket did not refill: %+v", result)
+	}
+	if result, _ := store.Take(ctx, "key", limit); result.Allowed {
+		t.Error("bucket refilled more than one token a second")
+	}
+}
+
+func TestMemoryStoreSweepsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	limit := Limit{Requests: 1, Period: time.Second}
+	ctx := context.Background()
+
+	store.Take(ctx, "key", limit)
+	store.buckets["key"].full = time.Now().Add(-time.Second)
+	store.lastSweep = time.Now().Add(-sweepInterval)
+
+	store.Take(ctx, "other", limit)
+	if _, ok := store.buckets["key"]; ok {
+		t.Error("full bucket was not swept")
+	}
+}